@@ -0,0 +1,235 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	GroupsClaim  string
+	GroupRoles   GroupRoles
+	DefaultRole  Role
+	SessionKey   []byte
+	SessionTTL   time.Duration
+	// InsecureAdmin serves the admin routes to anyone while login is
+	// disabled. It is meant for local development only.
+	InsecureAdmin bool
+}
+
+type User struct {
+	Subject string   `json:"sub"`
+	Email   string   `json:"email"`
+	Name    string   `json:"name"`
+	Groups  []string `json:"groups"`
+	Role    Role     `json:"role"`
+}
+
+var (
+	ErrDisabled     = errors.New("auth: OpenID Connect is not configured")
+	ErrInvalidState = errors.New("auth: invalid or expired login state")
+)
+
+var (
+	config        Config
+	insecureAdmin bool
+	provider      *oidc.Provider
+	verifier      *oidc.IDTokenVerifier
+	oauth         *oauth2.Config
+)
+
+// Setup fetches the provider's discovery document and prepares the
+// authorization-code flow. An empty issuer leaves authentication disabled.
+func Setup(ctx context.Context, cfg Config) error {
+	insecureAdmin = cfg.InsecureAdmin
+	if cfg.Issuer == "" {
+		return nil
+	}
+	if len(cfg.SessionKey) < 32 {
+		return errors.New("auth: session key must be at least 32 bytes")
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	if cfg.SessionTTL == 0 {
+		cfg.SessionTTL = 8 * time.Hour
+	}
+
+	p, err := oidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return fmt.Errorf("auth: discovery failed: %w", err)
+	}
+
+	config = cfg
+	provider = p
+	verifier = p.Verifier(&oidc.Config{ClientID: cfg.ClientID})
+	oauth = &oauth2.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  cfg.RedirectURL,
+		Endpoint:     p.Endpoint(),
+		Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+	}
+	return nil
+}
+
+func Enabled() bool {
+	return provider != nil
+}
+
+// AdminOpen reports whether the admin routes are served without login,
+// which only happens when login is disabled and InsecureAdmin is set.
+func AdminOpen() bool {
+	return !Enabled() && insecureAdmin
+}
+
+// LoginURL starts a login: it returns the provider's authorization URL and
+// a cookie carrying the state, nonce and PKCE verifier for the callback.
+func LoginURL(returnTo string) (string, *http.Cookie, error) {
+	if !Enabled() {
+		return "", nil, ErrDisabled
+	}
+	state, err := randomString()
+	if err != nil {
+		return "", nil, err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", nil, err
+	}
+	pending := pendingLogin{
+		State:    state,
+		Nonce:    nonce,
+		Verifier: oauth2.GenerateVerifier(),
+		ReturnTo: safeReturnTo(returnTo),
+		Expiry:   time.Now().Add(10 * time.Minute).Unix(),
+	}
+	cookie, err := encodeCookie(loginCookie, pending, pending.Expiry)
+	if err != nil {
+		return "", nil, err
+	}
+
+	url := oauth.AuthCodeURL(state,
+		oidc.Nonce(nonce),
+		oauth2.S256ChallengeOption(pending.Verifier),
+	)
+	return url, cookie, nil
+}
+
+// Exchange completes a login started by LoginURL. It redeems the code,
+// verifies the ID token against the provider's JWKS and maps the user's
+// groups to a role. It returns the session cookie and the page to return to.
+func Exchange(ctx context.Context, r *http.Request) (*http.Cookie, string, error) {
+	if !Enabled() {
+		return nil, "", ErrDisabled
+	}
+	var pending pendingLogin
+	if err := decodeCookie(r, loginCookie, &pending); err != nil {
+		return nil, "", ErrInvalidState
+	}
+	if r.URL.Query().Get("state") != pending.State {
+		return nil, "", ErrInvalidState
+	}
+	if e := r.URL.Query().Get("error"); e != "" {
+		return nil, "", fmt.Errorf("auth: provider returned %s: %s", e, r.URL.Query().Get("error_description"))
+	}
+
+	token, err := oauth.Exchange(ctx, r.URL.Query().Get("code"), oauth2.VerifierOption(pending.Verifier))
+	if err != nil {
+		return nil, "", fmt.Errorf("auth: code exchange failed: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, "", errors.New("auth: token response has no id_token")
+	}
+	user, nonce, err := verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, "", err
+	}
+	if nonce != pending.Nonce {
+		return nil, "", errors.New("auth: ID token nonce mismatch")
+	}
+
+	expiry := time.Now().Add(config.SessionTTL).Unix()
+	cookie, err := encodeCookie(sessionCookie, session{User: *user, Expiry: expiry}, expiry)
+	if err != nil {
+		return nil, "", err
+	}
+	return cookie, pending.ReturnTo, nil
+}
+
+// Authenticate resolves the user behind a request from either the session
+// cookie or a bearer ID token. It returns nil when the request is anonymous.
+func Authenticate(r *http.Request) (*User, error) {
+	if !Enabled() {
+		return nil, nil
+	}
+	if h := r.Header.Get("Authorization"); len(h) > 7 && h[:7] == "Bearer " {
+		user, _, err := verify(r.Context(), h[7:])
+		return user, err
+	}
+
+	// A missing, expired or tampered session cookie is just an anonymous
+	// request; the user can still reach /auth/login to get a new one.
+	var s session
+	if err := decodeCookie(r, sessionCookie, &s); err != nil {
+		return nil, nil
+	}
+	return &s.User, nil
+}
+
+func verify(ctx context.Context, rawIDToken string) (*User, string, error) {
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, "", fmt.Errorf("auth: ID token verification failed: %w", err)
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, "", err
+	}
+	user := User{
+		Subject: idToken.Subject,
+		Groups:  stringsClaim(claims[config.GroupsClaim]),
+	}
+	user.Email, _ = claims["email"].(string)
+	user.Name, _ = claims["name"].(string)
+	user.Role = RoleForGroups(user.Groups)
+	return &user, idToken.Nonce, nil
+}
+
+func stringsClaim(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+type userKey struct{}
+
+func WithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+func UserFromContext(ctx context.Context) *User {
+	user, _ := ctx.Value(userKey{}).(*User)
+	return user
+}
@@ -0,0 +1,388 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID     = "movie-database"
+	testClientSecret = "client-secret"
+	testRedirectURL  = "http://localhost/auth/callback"
+)
+
+// fakeProvider is a stand-in OpenID Connect provider. It serves discovery,
+// its JWKS, an authorization endpoint that approves every request at once
+// and a token endpoint that checks the PKCE verifier.
+type fakeProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	codes  map[string]authorization
+	claims map[string]any
+	// nonce, if set, replaces the nonce of issued ID tokens.
+	nonce string
+}
+
+type authorization struct {
+	challenge string
+	nonce     string
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeProvider{
+		key:    key,
+		codes:  map[string]authorization{},
+		claims: map[string]any{"email": "ada@example.com", "groups": []string{"cinema-admins"}},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+			"code_challenge_methods_supported":      []string{"S256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("GET /authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("client_id") != testClientID || q.Get("redirect_uri") != testRedirectURL ||
+			q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" || q.Get("nonce") == "" {
+			http.Error(w, "invalid_request", http.StatusBadRequest)
+			return
+		}
+		code := randomTestString(t)
+		p.mu.Lock()
+		p.codes[code] = authorization{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+		p.mu.Unlock()
+		http.Redirect(w, r, q.Get("redirect_uri")+"?"+url.Values{"code": {code}, "state": {q.Get("state")}}.Encode(), http.StatusFound)
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok {
+			id, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+		}
+		if id != testClientID || secret != testClientSecret {
+			tokenError(w, "invalid_client")
+			return
+		}
+		p.mu.Lock()
+		authz, ok := p.codes[r.PostFormValue("code")]
+		delete(p.codes, r.PostFormValue("code"))
+		p.mu.Unlock()
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != authz.challenge {
+			tokenError(w, "invalid_grant")
+			return
+		}
+		nonce := authz.nonce
+		if p.nonce != "" {
+			nonce = p.nonce
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     p.idToken(t, p.key, nonce),
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+// idToken signs an RS256 ID token for user ada with key.
+func (p *fakeProvider) idToken(t *testing.T, key *rsa.PrivateKey, nonce string) string {
+	t.Helper()
+	claims := map[string]any{
+		"iss": p.URL,
+		"sub": "ada",
+		"aud": testClientID,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	for k, v := range p.claims {
+		claims[k] = v
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func randomTestString(t *testing.T) string {
+	s, err := randomString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// setupTestAuth points the package at p and restores the disabled state
+// when the test ends.
+func setupTestAuth(t *testing.T, p *fakeProvider) {
+	t.Helper()
+	err := Setup(context.Background(), Config{
+		Issuer:       p.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+		GroupRoles:   GroupRoles{"cinema-admins": RoleAdmin, "cinema": RoleEditor},
+		DefaultRole:  RoleViewer,
+		SessionKey:   []byte(strings.Repeat("k", 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		config, provider, verifier, oauth = Config{}, nil, nil, nil
+	})
+}
+
+// authorize starts a login and follows the provider's redirect, returning
+// the callback request the browser would send.
+func authorize(t *testing.T, returnTo string) *http.Request {
+	t.Helper()
+	loginURL, cookie, err := LoginURL(returnTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(loginURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: status %d, want 302", resp.StatusCode)
+	}
+	callback := httptest.NewRequest("GET", resp.Header.Get("Location"), nil)
+	callback.AddCookie(cookie)
+	return callback
+}
+
+func TestLoginFlow(t *testing.T) {
+	p := newFakeProvider(t)
+	setupTestAuth(t, p)
+
+	cookie, returnTo, err := Exchange(context.Background(), authorize(t, "/films/?year=1999"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if returnTo != "/films/?year=1999" {
+		t.Errorf("returnTo = %q, want /films/?year=1999", returnTo)
+	}
+	if cookie.Name != sessionCookie || !cookie.HttpOnly {
+		t.Errorf("got cookie %q (HttpOnly %v), want an HttpOnly session cookie", cookie.Name, cookie.HttpOnly)
+	}
+
+	r := httptest.NewRequest("GET", "/films/", nil)
+	r.AddCookie(cookie)
+	user, err := Authenticate(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user == nil || user.Subject != "ada" || user.Email != "ada@example.com" || user.Role != RoleAdmin {
+		t.Errorf("Authenticate = %+v, want ada@example.com as admin", user)
+	}
+}
+
+func TestLoginMapsGroupsToRoles(t *testing.T) {
+	p := newFakeProvider(t)
+	setupTestAuth(t, p)
+
+	for _, tt := range []struct {
+		groups []string
+		want   Role
+	}{
+		{[]string{"cinema"}, RoleEditor},
+		{[]string{"cinema", "cinema-admins"}, RoleAdmin},
+		{[]string{"accounting"}, RoleViewer},
+		{nil, RoleViewer},
+	} {
+		p.claims["groups"] = tt.groups
+		cookie, _, err := Exchange(context.Background(), authorize(t, "/"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(cookie)
+		user, err := Authenticate(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Role != tt.want {
+			t.Errorf("groups %v: role %q, want %q", tt.groups, user.Role, tt.want)
+		}
+	}
+}
+
+func TestLoginRejectsWrongState(t *testing.T) {
+	p := newFakeProvider(t)
+	setupTestAuth(t, p)
+
+	callback := authorize(t, "/")
+	q := callback.URL.Query()
+	q.Set("state", "forged")
+	callback.URL.RawQuery = q.Encode()
+	_, _, err := Exchange(context.Background(), callback)
+	if !errors.Is(err, ErrInvalidState) {
+		t.Errorf("Exchange with a forged state: %v, want ErrInvalidState", err)
+	}
+}
+
+func TestLoginRejectsWrongVerifier(t *testing.T) {
+	p := newFakeProvider(t)
+	setupTestAuth(t, p)
+
+	callback := authorize(t, "/")
+	var pending pendingLogin
+	if err := decodeCookie(callback, loginCookie, &pending); err != nil {
+		t.Fatal(err)
+	}
+	pending.Verifier = "not-the-verifier-the-challenge-was-made-from-at-all"
+	cookie, err := encodeCookie(loginCookie, pending, pending.Expiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback.Header.Del("Cookie")
+	callback.AddCookie(cookie)
+
+	_, _, err = Exchange(context.Background(), callback)
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("Exchange with the wrong PKCE verifier: %v, want invalid_grant", err)
+	}
+}
+
+func TestLoginRejectsWrongNonce(t *testing.T) {
+	p := newFakeProvider(t)
+	setupTestAuth(t, p)
+	p.nonce = "replayed"
+
+	_, _, err := Exchange(context.Background(), authorize(t, "/"))
+	if err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Errorf("Exchange with a token for another nonce: %v, want a nonce mismatch", err)
+	}
+}
+
+func TestLoginReturnToStaysLocal(t *testing.T) {
+	p := newFakeProvider(t)
+	setupTestAuth(t, p)
+
+	for returnTo, want := range map[string]string{
+		"/films/1":                 "/films/1",
+		"/films/?year=1999":        "/films/?year=1999",
+		"https://evil.example/":    "/",
+		"//evil.example/":          "/",
+		"javascript:alert(1)":      "/",
+		"films/1":                  "/",
+		"":                         "/",
+		"/\\evil.example":          "/%5Cevil.example",
+		"http://localhost/films/1": "/",
+	} {
+		_, got, err := Exchange(context.Background(), authorize(t, returnTo))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("returnTo %q: got %q, want %q", returnTo, got, want)
+		}
+	}
+}
+
+func TestAuthenticateBearerToken(t *testing.T) {
+	p := newFakeProvider(t)
+	setupTestAuth(t, p)
+
+	r := httptest.NewRequest("GET", "/films/", nil)
+	r.Header.Set("Authorization", "Bearer "+p.idToken(t, p.key, ""))
+	user, err := Authenticate(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user == nil || user.Subject != "ada" {
+		t.Errorf("Authenticate = %+v, want ada", user)
+	}
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Authorization", "Bearer "+p.idToken(t, other, ""))
+	if _, err := Authenticate(r); err == nil {
+		t.Error("Authenticate accepted a token signed by a key outside the JWKS")
+	}
+}
+
+func TestAuthenticateIgnoresTamperedSession(t *testing.T) {
+	p := newFakeProvider(t)
+	setupTestAuth(t, p)
+
+	cookie, _, err := Exchange(context.Background(), authorize(t, "/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, mac, _ := strings.Cut(cookie.Value, ".")
+	raw, _ := base64.RawURLEncoding.DecodeString(payload)
+	forged := strings.Replace(string(raw), `"role":"admin"`, `"role":"viewer"`, 1)
+	cookie.Value = base64.RawURLEncoding.EncodeToString([]byte(forged)) + "." + mac
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	user, err := Authenticate(r)
+	if err != nil || user != nil {
+		t.Errorf("Authenticate with a tampered session = %+v, %v; want anonymous", user, err)
+	}
+}
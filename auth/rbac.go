@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type Role string
+
+const (
+	RoleNone   Role = ""
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleAdmin  Role = "admin"
+)
+
+var rank = map[Role]int{
+	RoleNone:   0,
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+func ParseRole(s string) (Role, error) {
+	role := Role(strings.ToLower(strings.TrimSpace(s)))
+	if _, ok := rank[role]; !ok {
+		return RoleNone, fmt.Errorf("auth: unknown role %q", s)
+	}
+	return role, nil
+}
+
+func (r *Role) UnmarshalText(text []byte) error {
+	role, err := ParseRole(string(text))
+	if err != nil {
+		return err
+	}
+	*r = role
+	return nil
+}
+
+// GroupRoles maps identity provider groups to roles.
+type GroupRoles map[string]Role
+
+// ParseGroupRoles parses a mapping such as "sso-admins=admin,cinema=editor".
+func ParseGroupRoles(s string) (GroupRoles, error) {
+	mapping := GroupRoles{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		group, role, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("auth: malformed group mapping %q", pair)
+		}
+		r, err := ParseRole(role)
+		if err != nil {
+			return nil, err
+		}
+		mapping[strings.TrimSpace(group)] = r
+	}
+	return mapping, nil
+}
+
+func (g *GroupRoles) UnmarshalText(text []byte) error {
+	mapping, err := ParseGroupRoles(string(text))
+	if err != nil {
+		return err
+	}
+	*g = mapping
+	return nil
+}
+
+// Includes reports whether r grants everything that required grants.
+func (r Role) Includes(required Role) bool {
+	return rank[r] >= rank[required]
+}
+
+// RoleForGroups returns the highest role granted by any of the groups,
+// falling back to the configured default role.
+func RoleForGroups(groups []string) Role {
+	role := config.DefaultRole
+	for _, g := range groups {
+		if mapped, ok := config.GroupRoles[g]; ok && mapped.Includes(role) {
+			role = mapped
+		}
+	}
+	return role
+}
+
+// RequiredRole returns the role a request needs. Login, documentation,
+// health and metrics routes are public, so probes and scrapers need no
+// login; reads need a viewer, writes an editor and the admin routes an
+// admin.
+func RequiredRole(r *http.Request) Role {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/auth/"), strings.HasPrefix(r.URL.Path, "/docs/"),
+		r.URL.Path == "/healthz", r.URL.Path == "/readyz", r.URL.Path == "/metrics":
+		return RoleNone
+	case strings.HasPrefix(r.URL.Path, "/admin/"):
+		return RoleAdmin
+	case r.Method == http.MethodGet, r.Method == http.MethodHead, r.Method == http.MethodOptions:
+		return RoleViewer
+	}
+	return RoleEditor
+}
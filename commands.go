@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go-test/config"
+	"go-test/database"
+	"go-test/importer"
+	"go-test/logging"
+	"go-test/seed"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// commands are the subcommands run instead of the server when named as the
+// first argument. Each returns the exit code.
+var commands = map[string]func(ctx context.Context, args []string) int{
+	"import":  runImport,
+	"backup":  runBackup,
+	"restore": runRestore,
+	"seed":    runSeed,
+	"check":   runCheck,
+}
+
+// setupCommand loads the configuration for a subcommand whose own flags
+// are already defined on fs, and connects to the database. A code of zero
+// or more means the command should exit with it right away.
+func setupCommand(ctx context.Context, fs *flag.FlagSet, args []string) ([]string, int) {
+	cfg, rest, err := config.LoadCommand(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil, 0
+	}
+	if err != nil {
+		os.Stderr.WriteString(err.Error() + "\n")
+		return nil, 2
+	}
+	err = logging.Setup(os.Stderr, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		os.Stderr.WriteString(err.Error() + "\n")
+		return nil, 2
+	}
+	err = database.SetupDB(ctx, poolConfig(cfg.DB))
+	if err != nil {
+		os.Stderr.WriteString("Unable to connect to database: " + err.Error() + "\n")
+		return nil, 1
+	}
+	return rest, -1
+}
+
+// runImport loads a CSV or NDJSON file, or standard input, like
+// POST /import/{entity} and prints the report.
+func runImport(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s import -entity <entity> [flags] [file|-]\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	entity := fs.String("entity", "", "directors, actors, films or characters")
+	format := fs.String("format", "", "csv or ndjson, by default taken from the file extension")
+	dryRun := fs.Bool("dry-run", false, "only validate the rows")
+	var pairs []string
+	fs.Func("map", "rename a column or key as <source>=<field>, or skip it with <source>=-; may be repeated", func(v string) error {
+		pairs = append(pairs, v)
+		return nil
+	})
+
+	rest, code := setupCommand(ctx, fs, args)
+	if code >= 0 {
+		return code
+	}
+	defer database.Close()
+
+	if len(rest) > 1 {
+		fs.Usage()
+		return 2
+	}
+	mapping, err := importer.ParseMapping(pairs)
+	if err != nil {
+		os.Stderr.WriteString(err.Error() + "\n")
+		return 2
+	}
+
+	var in io.Reader = os.Stdin
+	if len(rest) == 1 && rest[0] != "-" {
+		f, err := os.Open(rest[0])
+		if err != nil {
+			os.Stderr.WriteString(err.Error() + "\n")
+			return 1
+		}
+		defer f.Close()
+		in = f
+		if *format == "" {
+			switch strings.ToLower(filepath.Ext(rest[0])) {
+			case ".csv":
+				*format = "csv"
+			case ".ndjson", ".jsonl":
+				*format = "ndjson"
+			}
+		}
+	}
+
+	report, err := importer.Run(ctx, in, importer.Options{
+		Entity:  *entity,
+		Format:  *format,
+		Mapping: mapping,
+		DryRun:  *dryRun,
+	})
+	if report != nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	}
+	if err != nil {
+		os.Stderr.WriteString(err.Error() + "\n")
+		return 1
+	}
+	return 0
+}
+
+// runBackup writes a backup archive like GET /admin/backup to a file or
+// standard output.
+func runBackup(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s backup [flags] [file|-]\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	rest, code := setupCommand(ctx, fs, args)
+	if code >= 0 {
+		return code
+	}
+	defer database.Close()
+
+	if len(rest) > 1 {
+		fs.Usage()
+		return 2
+	}
+	out := os.Stdout
+	if len(rest) == 1 && rest[0] != "-" {
+		f, err := os.Create(rest[0])
+		if err != nil {
+			os.Stderr.WriteString(err.Error() + "\n")
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+
+	manifest, err := database.Backup(ctx, out)
+	if err == nil {
+		err = out.Sync()
+	}
+	if err != nil {
+		if out != os.Stdout {
+			os.Remove(out.Name())
+		}
+		os.Stderr.WriteString(err.Error() + "\n")
+		return 1
+	}
+	for _, t := range manifest.Tables {
+		fmt.Fprintf(os.Stderr, "%s: %d rows\n", t.Name, t.Rows)
+	}
+	return 0
+}
+
+// runRestore replaces every table with a backup archive read from a file
+// or standard input, like POST /admin/restore.
+func runRestore(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s restore [flags] file|-\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	rest, code := setupCommand(ctx, fs, args)
+	if code >= 0 {
+		return code
+	}
+	defer database.Close()
+
+	if len(rest) != 1 {
+		fs.Usage()
+		return 2
+	}
+	var in io.Reader = os.Stdin
+	if rest[0] != "-" {
+		f, err := os.Open(rest[0])
+		if err != nil {
+			os.Stderr.WriteString(err.Error() + "\n")
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+
+	manifest, err := database.Restore(ctx, in)
+	if err != nil {
+		os.Stderr.WriteString(err.Error() + "\n")
+		return 1
+	}
+	for _, t := range manifest.Tables {
+		fmt.Fprintf(os.Stderr, "%s: %d rows\n", t.Name, t.Rows)
+	}
+	return 0
+}
+
+// runSeed loads the development fixtures and, with -synthetic, generated
+// records for load testing, and prints what changed.
+func runSeed(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	fixtures := fs.Bool("fixtures", true, "load the curated fixtures")
+	synthetic := fs.Int("synthetic", 0, "also generate this many directors, actors, films and characters")
+	randomSeed := fs.Uint64("seed", 1, "seed of the generator; the same seed yields the same records")
+	rest, code := setupCommand(ctx, fs, args)
+	if code >= 0 {
+		return code
+	}
+	defer database.Close()
+
+	if len(rest) > 0 || *synthetic < 0 {
+		fs.Usage()
+		return 2
+	}
+	reports := map[string]*seed.Report{}
+	if *fixtures {
+		report, err := seed.Fixtures(ctx)
+		if err != nil {
+			os.Stderr.WriteString(err.Error() + "\n")
+			return 1
+		}
+		reports["fixtures"] = report
+	}
+	if *synthetic > 0 {
+		report, err := seed.Synthetic(ctx, *synthetic, *randomSeed)
+		if err != nil {
+			os.Stderr.WriteString(err.Error() + "\n")
+			return 1
+		}
+		reports["synthetic"] = report
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(reports)
+	return 0
+}
+
+// runCheck runs the integrity rules like GET /admin/check, or POST with
+// -fix, and exits with 1 if any rule has findings left.
+func runCheck(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	format := fs.String("format", "table", "table or json")
+	fix := fs.Bool("fix", false, "repair what the fixable rules can before reporting")
+	var rules []string
+	fs.Func("rule", "run only this rule; may be repeated ("+strings.Join(database.IntegrityRules(), ", ")+")", func(v string) error {
+		rules = append(rules, v)
+		return nil
+	})
+	rest, code := setupCommand(ctx, fs, args)
+	if code >= 0 {
+		return code
+	}
+	defer database.Close()
+
+	if len(rest) > 0 || (*format != "table" && *format != "json") {
+		fs.Usage()
+		return 2
+	}
+	report, err := database.CheckIntegrity(ctx, rules, *fix)
+	if err != nil {
+		os.Stderr.WriteString(err.Error() + "\n")
+		return 1
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = report.WriteTable(os.Stdout)
+	}
+	if err != nil {
+		os.Stderr.WriteString(err.Error() + "\n")
+		return 1
+	}
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,213 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Config holds every setting of the server. Each field is named by its key
+// in the config file; the env tag names the environment variable and the
+// flag is "-<section>-<key>" with underscores turned into dashes.
+type Config struct {
+	DB      DB      `key:"db"`
+	HTTP    HTTP    `key:"http"`
+	Auth    Auth    `key:"auth"`
+	Cache   Cache   `key:"cache"`
+	Log     Log     `key:"log"`
+	Tracing Tracing `key:"tracing"`
+}
+
+type DB struct {
+	URL      string `key:"url" env:"DATABASE_URL" usage:"full connection URL, overrides the other db settings"`
+	Host     string `key:"host" env:"DB_HOST" default:"localhost"`
+	Port     int    `key:"port" env:"DB_PORT" default:"5432"`
+	User     string `key:"user" env:"DB_USER"`
+	Password string `key:"password" env:"DB_PASSWORD" secret:"true"`
+	Name     string `key:"name" env:"DB_NAME"`
+
+	MaxConns           int           `key:"max_conns" env:"DB_MAX_CONNS" usage:"pool size, 0 for the pgx default"`
+	MinConns           int           `key:"min_conns" env:"DB_MIN_CONNS"`
+	MaxConnLifetime    time.Duration `key:"max_conn_lifetime" env:"DB_MAX_CONN_LIFETIME"`
+	MaxConnIdleTime    time.Duration `key:"max_conn_idle_time" env:"DB_MAX_CONN_IDLE_TIME"`
+	HealthCheckPeriod  time.Duration `key:"health_check_period" env:"DB_HEALTH_CHECK_PERIOD"`
+	StatementCacheMode string        `key:"statement_cache_mode" env:"DB_STATEMENT_CACHE_MODE" default:"cache_statement" usage:"cache_statement, cache_describe, describe_exec, exec or simple_protocol"`
+	ApplicationName    string        `key:"application_name" env:"DB_APPLICATION_NAME" default:"go-net-http-server"`
+	SearchPath         string        `key:"search_path" env:"DB_SEARCH_PATH" usage:"empty keeps the server default"`
+	ConnectAttempts    int           `key:"connect_attempts" env:"DB_CONNECT_ATTEMPTS" default:"5" usage:"pings at startup before giving up"`
+	QueryLogLevel      string        `key:"query_log_level" env:"DB_QUERY_LOG_LEVEL" default:"warn" usage:"trace, debug, info, warn, error or none; info logs every query, warn only failures"`
+	MigrationsTable    string        `key:"migrations_table" env:"DB_MIGRATIONS_TABLE" default:"schema_migrations" usage:"table tern migrate --version-table records the schema version in"`
+}
+
+type HTTP struct {
+	Addr               string        `key:"addr" env:"HOST" default:":http" usage:"address to listen on"`
+	MetricsAddr        string        `key:"metrics_addr" env:"METRICS_ADDR" usage:"separate address for /metrics"`
+	Dev                bool          `key:"dev" env:"DEV_MODE" usage:"include panic stacks in error responses"`
+	TrustProxy         bool          `key:"trust_proxy" env:"TRUST_PROXY" usage:"take client IPs from X-Forwarded-For"`
+	ReadHeaderTimeout  time.Duration `key:"read_header_timeout" env:"HTTP_READ_HEADER_TIMEOUT" default:"5s"`
+	ReadTimeout        time.Duration `key:"read_timeout" env:"HTTP_READ_TIMEOUT" default:"5s"`
+	UploadReadTimeout  time.Duration `key:"upload_read_timeout" env:"HTTP_UPLOAD_READ_TIMEOUT" default:"10m" usage:"time to read the body of an upload such as an import, instead of read_timeout"`
+	HandlerTimeout     time.Duration `key:"handler_timeout" env:"HTTP_HANDLER_TIMEOUT" default:"5s" usage:"time a handler may take unless route_timeouts says otherwise"`
+	RouteTimeouts      string        `key:"route_timeouts" env:"HTTP_ROUTE_TIMEOUTS" usage:"per-route timeouts, e.g. GET /films/=2s,POST /batch=1m"`
+	DrainDelay         time.Duration `key:"drain_delay" env:"SHUTDOWN_DRAIN_DELAY" default:"5s"`
+	ShutdownTimeout    time.Duration `key:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" default:"30s"`
+	RateLimitRead      string        `key:"rate_limit_read" env:"RATE_LIMIT_READ" usage:"e.g. 300/1m, empty for no limit"`
+	RateLimitWrite     string        `key:"rate_limit_write" env:"RATE_LIMIT_WRITE" usage:"e.g. 60/1m, empty for no limit"`
+	RateLimitStore     string        `key:"rate_limit_store" env:"RATE_LIMIT_STORE" default:"memory" usage:"memory or postgres"`
+	RateLimitAPIKeys   string        `key:"rate_limit_api_keys" env:"RATE_LIMIT_API_KEYS" secret:"true" usage:"comma-separated X-API-Key values limited per key; other keys are limited by IP"`
+	CacheControl       string        `key:"cache_control" env:"HTTP_CACHE_CONTROL" default:"private, no-cache" usage:"Cache-Control of GET responses"`
+	RouteCacheControl  string        `key:"route_cache_control" env:"HTTP_ROUTE_CACHE_CONTROL" usage:"per-route Cache-Control, e.g. GET /films/{id}=private, max-age=60;GET /docs/=public, max-age=3600"`
+	MaxBodyBytes       int           `key:"max_body_bytes" env:"HTTP_MAX_BODY_BYTES" default:"1048576" usage:"largest JSON request body accepted"`
+	BatchMaxOperations int           `key:"batch_max_operations" env:"HTTP_BATCH_MAX_OPERATIONS" default:"1000" usage:"most operations one POST /batch may hold"`
+	ImportMaxBytes     int           `key:"import_max_bytes" env:"HTTP_IMPORT_MAX_BYTES" default:"67108864" usage:"largest CSV or NDJSON body accepted by POST /import/{entity}"`
+	IdempotencyWindow  time.Duration `key:"idempotency_window" env:"HTTP_IDEMPOTENCY_WINDOW" default:"24h" usage:"how long responses to POSTs with an Idempotency-Key are replayed"`
+	IdempotencyMaxBody int           `key:"idempotency_max_body" env:"HTTP_IDEMPOTENCY_MAX_BODY" default:"1048576" usage:"largest POST body in bytes accepted with an Idempotency-Key"`
+	CompressMinSize    int           `key:"compress_min_size" env:"HTTP_COMPRESS_MIN_SIZE" default:"1024" usage:"smallest response body in bytes worth compressing"`
+	CompressBrotli     bool          `key:"compress_brotli" env:"HTTP_COMPRESS_BROTLI" usage:"offer br besides zstd and gzip"`
+}
+
+type Auth struct {
+	Issuer        string        `key:"issuer" env:"OIDC_ISSUER" usage:"OpenID Connect issuer, empty disables login"`
+	ClientID      string        `key:"client_id" env:"OIDC_CLIENT_ID"`
+	ClientSecret  string        `key:"client_secret" env:"OIDC_CLIENT_SECRET" secret:"true"`
+	RedirectURL   string        `key:"redirect_url" env:"OIDC_REDIRECT_URL"`
+	GroupsClaim   string        `key:"groups_claim" env:"OIDC_GROUPS_CLAIM" default:"groups"`
+	GroupRoles    string        `key:"group_roles" env:"OIDC_GROUP_ROLES" usage:"e.g. sso-admins=admin,cinema=editor"`
+	DefaultRole   string        `key:"default_role" env:"OIDC_DEFAULT_ROLE" usage:"role of users in no mapped group"`
+	SessionSecret string        `key:"session_secret" env:"SESSION_SECRET" secret:"true"`
+	SessionTTL    time.Duration `key:"session_ttl" env:"SESSION_TTL" default:"8h"`
+	InsecureAdmin bool          `key:"insecure_admin" env:"AUTH_INSECURE_ADMIN" usage:"serve /admin/ routes without login while the issuer is empty; development only"`
+}
+
+type Cache struct {
+	Size int           `key:"size" env:"CACHE_SIZE" default:"10000" usage:"entities cached per type, 0 disables the cache"`
+	TTL  time.Duration `key:"ttl" env:"CACHE_TTL" default:"1m"`
+}
+
+type Log struct {
+	Format string `key:"format" env:"LOG_FORMAT" default:"text" usage:"text or json"`
+	Level  string `key:"level" env:"LOG_LEVEL" default:"info" usage:"debug, info, warn or error"`
+}
+
+type Tracing struct {
+	Exporter string `key:"exporter" env:"TRACING_EXPORTER" usage:"otlp, stdout or empty"`
+}
+
+// DSN returns the connection URL, escaping the credentials.
+func (db DB) DSN() string {
+	if db.URL != "" {
+		return db.URL
+	}
+	u := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(db.User, db.Password),
+		Host:   net.JoinHostPort(db.Host, strconv.Itoa(db.Port)),
+		Path:   "/" + db.Name,
+	}
+	return u.String()
+}
+
+// Validate reports every problem with the configuration at once.
+func (c *Config) Validate() error {
+	var errs errorList
+	if c.DB.URL == "" {
+		errs.require(c.DB.Host, "db.host")
+		errs.require(c.DB.User, "db.user")
+		errs.require(c.DB.Name, "db.name")
+		if c.DB.Port <= 0 || c.DB.Port > 65535 {
+			errs.add("db.port", "must be between 1 and 65535")
+		}
+	} else if _, err := url.Parse(c.DB.URL); err != nil {
+		errs.add("db.url", "is not a valid URL")
+	}
+	if c.DB.MaxConns < 0 || c.DB.MinConns < 0 {
+		errs.add("db.max_conns", "pool sizes must not be negative")
+	}
+	if c.DB.MaxConns > 0 && c.DB.MinConns > c.DB.MaxConns {
+		errs.add("db.min_conns", fmt.Sprintf("must not exceed db.max_conns (%d)", c.DB.MaxConns))
+	}
+	switch c.DB.StatementCacheMode {
+	case "cache_statement", "cache_describe", "describe_exec", "exec", "simple_protocol":
+	default:
+		errs.add("db.statement_cache_mode", fmt.Sprintf("unknown mode %q", c.DB.StatementCacheMode))
+	}
+	switch c.DB.QueryLogLevel {
+	case "trace", "debug", "info", "warn", "error", "none":
+	default:
+		errs.add("db.query_log_level", fmt.Sprintf("unknown level %q", c.DB.QueryLogLevel))
+	}
+	errs.require(c.DB.MigrationsTable, "db.migrations_table")
+	if c.DB.ConnectAttempts < 1 {
+		errs.add("db.connect_attempts", "must be at least 1")
+	}
+
+	for key, d := range map[string]time.Duration{
+		"http.read_header_timeout": c.HTTP.ReadHeaderTimeout,
+		"http.read_timeout":        c.HTTP.ReadTimeout,
+		"http.upload_read_timeout": c.HTTP.UploadReadTimeout,
+		"http.handler_timeout":     c.HTTP.HandlerTimeout,
+		"http.shutdown_timeout":    c.HTTP.ShutdownTimeout,
+		"http.idempotency_window":  c.HTTP.IdempotencyWindow,
+	} {
+		if d <= 0 {
+			errs.add(key, "must be positive")
+		}
+	}
+	if c.HTTP.DrainDelay < 0 {
+		errs.add("http.drain_delay", "must not be negative")
+	}
+	if c.HTTP.MaxBodyBytes <= 0 {
+		errs.add("http.max_body_bytes", "must be positive")
+	}
+	if c.HTTP.BatchMaxOperations <= 0 {
+		errs.add("http.batch_max_operations", "must be positive")
+	}
+	if c.HTTP.ImportMaxBytes <= 0 {
+		errs.add("http.import_max_bytes", "must be positive")
+	}
+	if c.HTTP.IdempotencyMaxBody <= 0 {
+		errs.add("http.idempotency_max_body", "must be positive")
+	}
+	if c.HTTP.CompressMinSize < 0 {
+		errs.add("http.compress_min_size", "must not be negative")
+	}
+	if c.HTTP.RateLimitStore != "memory" && c.HTTP.RateLimitStore != "postgres" {
+		errs.add("http.rate_limit_store", fmt.Sprintf("must be memory or postgres, not %q", c.HTTP.RateLimitStore))
+	}
+
+	if c.Cache.Size < 0 {
+		errs.add("cache.size", "must not be negative")
+	}
+	if c.Cache.TTL < 0 {
+		errs.add("cache.ttl", "must not be negative")
+	}
+
+	if c.Auth.Issuer != "" && c.Auth.InsecureAdmin {
+		errs.add("auth.insecure_admin", "must not be set when login is enabled")
+	}
+	if c.Auth.Issuer != "" {
+		errs.require(c.Auth.ClientID, "auth.client_id")
+		errs.require(c.Auth.RedirectURL, "auth.redirect_url")
+		if len(c.Auth.SessionSecret) < 32 {
+			errs.add("auth.session_secret", "must be at least 32 characters when login is enabled")
+		}
+	}
+
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		errs.add("log.format", fmt.Sprintf("must be text or json, not %q", c.Log.Format))
+	}
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		errs.add("log.level", fmt.Sprintf("must be debug, info, warn or error, not %q", c.Log.Level))
+	}
+
+	switch c.Tracing.Exporter {
+	case "", "otlp", "stdout":
+	default:
+		errs.add("tracing.exporter", fmt.Sprintf("must be otlp, stdout or empty, not %q", c.Tracing.Exporter))
+	}
+	return errs.err()
+}
@@ -0,0 +1,293 @@
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Load builds the configuration from, in increasing precedence, defaults,
+// the optional config file, the environment (including a .env file) and
+// the command line flags in args, then validates it.
+//
+// Every environment variable may instead be given as <NAME>_FILE holding a
+// path to read the value from, as with Docker and Kubernetes secrets.
+func Load(name string, args []string) (*Config, error) {
+	cfg, rest, err := LoadCommand(flag.NewFlagSet(name, flag.ContinueOnError), args)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(rest, " "))
+	}
+	return cfg, nil
+}
+
+// LoadCommand is Load for subcommands: fs may already define the
+// command's own flags, and the arguments left after the flags are
+// returned instead of rejected.
+func LoadCommand(fs *flag.FlagSet, args []string) (*Config, []string, error) {
+	err := godotenv.Load()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, fmt.Errorf(".env: %w", err)
+	}
+
+	var cfg Config
+	fields := fieldsOf(&cfg)
+
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a TOML or YAML config file (env CONFIG_FILE)")
+	flagged := map[string]string{}
+	for _, f := range fields {
+		usage := f.usage
+		if f.env != "" {
+			usage += " (env " + f.env + ")"
+		}
+		if f.def != "" {
+			usage += " (default " + f.def + ")"
+		}
+		usage = strings.TrimSpace(usage)
+		fs.Func(f.flag, usage, func(v string) error {
+			flagged[f.key] = v
+			return nil
+		})
+	}
+	if err = fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	var errs errorList
+	for _, f := range fields {
+		if f.def != "" {
+			errs.set(f, f.def, "default")
+		}
+	}
+
+	if *configFile != "" {
+		values, err := readFile(*configFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		known := map[string]field{}
+		for _, f := range fields {
+			known[f.key] = f
+		}
+		for key, v := range values {
+			f, ok := known[key]
+			if !ok {
+				errs.add(key, "unknown setting in "+*configFile)
+				continue
+			}
+			errs.set(f, v, *configFile)
+		}
+	}
+
+	for _, f := range fields {
+		if f.env == "" {
+			continue
+		}
+		v, ok, err := lookupEnv(f.env)
+		if err != nil {
+			errs.add(f.key, err.Error())
+			continue
+		}
+		if ok {
+			errs.set(f, v, "$"+f.env)
+		}
+	}
+
+	for _, f := range fields {
+		if v, ok := flagged[f.key]; ok {
+			errs.set(f, v, "-"+f.flag)
+		}
+	}
+
+	if err := errs.err(); err != nil {
+		return nil, nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return &cfg, fs.Args(), nil
+}
+
+func lookupEnv(name string) (string, bool, error) {
+	v, ok := os.LookupEnv(name)
+	path, fromFile := os.LookupEnv(name + "_FILE")
+	if ok && fromFile {
+		return "", false, fmt.Errorf("both %s and %s_FILE are set", name, name)
+	}
+	if !fromFile {
+		return v, ok, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("%s_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(b), "\r\n"), true, nil
+}
+
+// readFile flattens a TOML or YAML file into "section.key" settings.
+func readFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tree map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".toml":
+		err = toml.Unmarshal(b, &tree)
+	case ".yaml", ".yml":
+		err = yaml.NewDecoder(bytes.NewReader(b)).Decode(&tree)
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported config file type %q, want .toml, .yaml or .yml", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	flatten("", tree, values)
+	return values, nil
+}
+
+func flatten(prefix string, tree map[string]any, values map[string]string) {
+	for k, v := range tree {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		switch v := v.(type) {
+		case map[string]any:
+			flatten(key, v, values)
+		case nil:
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+}
+
+type field struct {
+	key    string
+	env    string
+	flag   string
+	def    string
+	usage  string
+	secret bool
+	value  reflect.Value
+}
+
+// fieldsOf lists the settings of cfg, sorted by key.
+func fieldsOf(cfg *Config) []field {
+	var fields []field
+	root := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		section := root.Type().Field(i)
+		for j := 0; j < section.Type.NumField(); j++ {
+			sf := section.Type.Field(j)
+			key := section.Tag.Get("key") + "." + sf.Tag.Get("key")
+			fields = append(fields, field{
+				key:    key,
+				env:    sf.Tag.Get("env"),
+				flag:   strings.NewReplacer(".", "-", "_", "-").Replace(key),
+				def:    sf.Tag.Get("default"),
+				usage:  sf.Tag.Get("usage"),
+				secret: sf.Tag.Get("secret") == "true",
+				value:  root.Field(i).Field(j),
+			})
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].key < fields[j].key })
+	return fields
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+func (f field) set(s string) error {
+	v := f.value
+	if reflect.PointerTo(v.Type()).Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return errors.New("not a duration like 5s or 1m30s")
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return errors.New("not an integer")
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return errors.New("not true or false")
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+type errorList []error
+
+func (l *errorList) add(key, msg string) {
+	*l = append(*l, fmt.Errorf("%s: %s", key, msg))
+}
+
+func (l *errorList) require(v, key string) {
+	if v == "" {
+		l.add(key, "is required")
+	}
+}
+
+// set assigns v to f, recording where the bad value came from on failure.
+// Secret values are never echoed back.
+func (l *errorList) set(f field, v, source string) {
+	err := f.set(v)
+	if err == nil {
+		return
+	}
+	if f.secret {
+		l.add(f.key, fmt.Sprintf("invalid value from %s: %s", source, err))
+		return
+	}
+	l.add(f.key, fmt.Sprintf("invalid value %q from %s: %s", v, source, err))
+}
+
+func (l errorList) err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(l))
+	for i, err := range l {
+		msgs[i] = err.Error()
+	}
+	sort.Strings(msgs)
+	return errors.New("invalid configuration:\n  " + strings.Join(msgs, "\n  "))
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// clearEnv unsets every variable the settings read, so the tests see only
+// what they set themselves.
+func clearEnv(t *testing.T) {
+	t.Helper()
+	names := []string{"CONFIG_FILE"}
+	var cfg Config
+	for _, f := range fieldsOf(&cfg) {
+		if f.env != "" {
+			names = append(names, f.env, f.env+"_FILE")
+		}
+	}
+	for _, name := range names {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	t.Setenv("DB_USER", "movies")
+	t.Setenv("DB_NAME", "movies")
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func load(t *testing.T, args ...string) (*Config, error) {
+	t.Helper()
+	return Load("test", args)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "config.toml", "[db]\nport = 5433\n")
+	for _, tt := range []struct {
+		name string
+		file bool
+		env  string
+		flag string
+		want int
+	}{
+		{name: "default", want: 5432},
+		{name: "file over default", file: true, want: 5433},
+		{name: "env over file", file: true, env: "5434", want: 5434},
+		{name: "flag over env", file: true, env: "5434", flag: "5435", want: 5435},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			var args []string
+			if tt.file {
+				args = append(args, "-config", file)
+			}
+			if tt.env != "" {
+				t.Setenv("DB_PORT", tt.env)
+			}
+			if tt.flag != "" {
+				args = append(args, "-db-port", tt.flag)
+			}
+			cfg, err := load(t, args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.DB.Port != tt.want {
+				t.Errorf("db.port = %d, want %d", cfg.DB.Port, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadYAMLSections(t *testing.T) {
+	clearEnv(t)
+	file := writeFile(t, "config.yaml", "http:\n  handler_timeout: 2s\nlog:\n  format: json\n")
+	cfg, err := load(t, "-config", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.HTTP.HandlerTimeout.String() != "2s" || cfg.Log.Format != "json" {
+		t.Errorf("got handler_timeout %s and log.format %q, want 2s and json", cfg.HTTP.HandlerTimeout, cfg.Log.Format)
+	}
+}
+
+func TestLoadSecretFromFile(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("DB_PASSWORD_FILE", writeFile(t, "password", "p@ss/word\n"))
+	cfg, err := load(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DB.Password != "p@ss/word" {
+		t.Errorf("db.password = %q, want the file without its trailing newline", cfg.DB.Password)
+	}
+	if dsn := cfg.DB.DSN(); !strings.Contains(dsn, "p%40ss%2Fword@") {
+		t.Errorf("DSN() = %q, want the password escaped", dsn)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		setup func(t *testing.T) []string
+		want  string
+	}{
+		{
+			name: "value and file both set",
+			setup: func(t *testing.T) []string {
+				t.Setenv("DB_PASSWORD", "secret")
+				t.Setenv("DB_PASSWORD_FILE", writeFile(t, "password", "secret"))
+				return nil
+			},
+			want: "both DB_PASSWORD and DB_PASSWORD_FILE are set",
+		},
+		{
+			name: "unknown file setting",
+			setup: func(t *testing.T) []string {
+				return []string{"-config", writeFile(t, "config.toml", "[db]\nprot = 5433\n")}
+			},
+			want: "db.prot: unknown setting",
+		},
+		{
+			name: "bad value names its source",
+			setup: func(t *testing.T) []string {
+				t.Setenv("HTTP_HANDLER_TIMEOUT", "soon")
+				return nil
+			},
+			want: `http.handler_timeout: invalid value "soon" from $HTTP_HANDLER_TIMEOUT`,
+		},
+		{
+			name: "validation",
+			setup: func(t *testing.T) []string {
+				return []string{"-db-port", "0", "-log-format", "xml"}
+			},
+			want: "db.port: must be between 1 and 65535",
+		},
+		{
+			name: "required setting",
+			setup: func(t *testing.T) []string {
+				os.Unsetenv("DB_USER")
+				return nil
+			},
+			want: "db.user: is required",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			_, err := load(t, tt.setup(t)...)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load: %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadHelp(t *testing.T) {
+	clearEnv(t)
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(new(strings.Builder))
+	_, _, err := LoadCommand(fs, []string{"-h"})
+	if err != flag.ErrHelp {
+		t.Errorf("LoadCommand -h: %v, want flag.ErrHelp", err)
+	}
+}
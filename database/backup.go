@@ -0,0 +1,453 @@
+package database
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// BackupFormat names the archives written by Backup.
+	BackupFormat = "movie-database-backup"
+	// BackupFormatVersion is the version of the archive layout. Restore
+	// only reads archives of this version.
+	BackupFormatVersion = 1
+)
+
+// backupManifestFile is the first file of every backup archive.
+const backupManifestFile = "manifest.json"
+
+// BackupManifest describes a backup archive. Each table is stored as a
+// JSON Lines file with one array of column values per row.
+type BackupManifest struct {
+	Format        string        `json:"format"`
+	FormatVersion int           `json:"formatVersion"`
+	SchemaVersion int           `json:"schemaVersion"`
+	CreatedAt     time.Time     `json:"createdAt"`
+	Tables        []BackupTable `json:"tables"`
+}
+
+type BackupTable struct {
+	Name    string   `json:"name"`
+	File    string   `json:"file"`
+	Columns []string `json:"columns"`
+	Rows    int      `json:"rows"`
+	SHA256  string   `json:"sha256"`
+}
+
+type backupColumn struct {
+	name string
+	// kind is int, text, bool or time.
+	kind string
+}
+
+// backupColumns lists every column of the tables in a backup, in foreign
+// key order so restoring them in order never refers ahead. A migration
+// adding a column must add it here too, unless it is generated like
+// name_key.
+var backupColumns = []struct {
+	table   string
+	columns []backupColumn
+}{
+	{"directors", []backupColumn{
+		{"id", "int"}, {"first_name", "text"}, {"middle_name", "text"}, {"last_name", "text"},
+		{"updated_at", "time"}, {"external_key", "text"},
+	}},
+	{"actors", []backupColumn{
+		{"id", "int"}, {"first_name", "text"}, {"middle_name", "text"}, {"last_name", "text"},
+		{"updated_at", "time"}, {"external_key", "text"},
+	}},
+	{"films", []backupColumn{
+		{"id", "int"}, {"title", "text"}, {"directed_by", "int"}, {"logline", "text"}, {"year", "int"},
+		{"updated_at", "time"}, {"external_key", "text"},
+	}},
+	{"characters", []backupColumn{
+		{"id", "int"}, {"name", "text"}, {"portrayed_by", "int"}, {"featured_in", "int"}, {"dies_in_the_end", "bool"},
+		{"updated_at", "time"}, {"external_key", "text"},
+	}},
+}
+
+func backupColumnNames(columns []backupColumn) []string {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.name
+	}
+	return names
+}
+
+// ArchiveError reports a backup archive that cannot be restored because it
+// is malformed or damaged.
+type ArchiveError struct {
+	Reason string
+}
+
+func (e *ArchiveError) Error() string {
+	return "backup archive: " + e.Reason
+}
+
+// SchemaMismatchError reports a backup taken at another schema version than
+// the database it is restored into.
+type SchemaMismatchError struct {
+	Archive, Database int
+}
+
+func (e *SchemaMismatchError) Error() string {
+	return fmt.Sprintf("backup archive has schema version %d, but the database is at version %d; restore it into a database migrated to version %d",
+		e.Archive, e.Database, e.Archive)
+}
+
+// Backup writes a gzipped tar archive of every table to w, all read from
+// one REPEATABLE READ snapshot. The tables are spooled to temporary files
+// first, so the manifest with their checksums can lead the archive.
+func Backup(ctx context.Context, w io.Writer) (*BackupManifest, error) {
+	manifest := &BackupManifest{
+		Format:        BackupFormat,
+		FormatVersion: BackupFormatVersion,
+		CreatedAt:     time.Now().UTC(),
+	}
+	var spools []*os.File
+	defer func() {
+		for _, f := range spools {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	err := Snapshot(ctx, func(e *Exporter) error {
+		var err error
+		manifest.SchemaVersion, err = schemaVersion(ctx, e.tx)
+		if err != nil {
+			return err
+		}
+		for _, t := range backupColumns {
+			spool, err := os.CreateTemp("", "backup-*.ndjson")
+			if err != nil {
+				return err
+			}
+			spools = append(spools, spool)
+			table, err := backupTable(ctx, e, t.table, t.columns, spool)
+			if err != nil {
+				return err
+			}
+			manifest.Tables = append(manifest.Tables, table)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	err = writeTarFile(tw, backupManifestFile, int64(len(b)), manifest.CreatedAt, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	for i, spool := range spools {
+		size, err := spool.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = spool.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		err = writeTarFile(tw, manifest.Tables[i].File, size, manifest.CreatedAt, spool)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return nil, err
+	}
+	if err = gz.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func backupTable(ctx context.Context, e *Exporter, table string, columns []backupColumn, w io.Writer) (BackupTable, error) {
+	names := backupColumnNames(columns)
+	t := BackupTable{Name: table, File: table + ".ndjson", Columns: names}
+	sum := sha256.New()
+	bw := bufio.NewWriter(io.MultiWriter(w, sum))
+	err := e.cursor(ctx, `SELECT `+strings.Join(names, ", ")+` FROM `+table+` ORDER BY id`, nil, func(values []any) error {
+		for i, v := range values {
+			if tv, ok := v.(time.Time); ok {
+				values[i] = tv.UTC()
+			}
+		}
+		b, err := json.Marshal(values)
+		if err != nil {
+			return err
+		}
+		bw.Write(b)
+		t.Rows++
+		return bw.WriteByte('\n')
+	})
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		return BackupTable{}, err
+	}
+	t.SHA256 = hex.EncodeToString(sum.Sum(nil))
+	return t, nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, size int64, modTime time.Time, r io.Reader) error {
+	err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: size, ModTime: modTime})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, r)
+	return err
+}
+
+// Restore replaces every table with the contents of a backup archive read
+// from r, in one transaction. Rows keep their ids, and the identity
+// sequences continue after the highest restored id. Archives of another
+// format or schema version, and files failing their checksum, are refused
+// and leave the database untouched.
+func Restore(ctx context.Context, r io.Reader) (*BackupManifest, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, &ArchiveError{Reason: "not a gzipped archive: " + err.Error()}
+	}
+	tr := tar.NewReader(gz)
+	manifest, err := readBackupManifest(tr)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := dbpool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	version, err := schemaVersion(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	if version != manifest.SchemaVersion {
+		return nil, &SchemaMismatchError{Archive: manifest.SchemaVersion, Database: version}
+	}
+	if err = checkBackupTables(manifest); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `TRUNCATE characters, films, actors, directors`)
+	if err != nil {
+		return nil, err
+	}
+	for i, t := range backupColumns {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, &ArchiveError{Reason: "missing " + manifest.Tables[i].File}
+		}
+		if err != nil {
+			return nil, &ArchiveError{Reason: err.Error()}
+		}
+		if hdr.Name != manifest.Tables[i].File {
+			return nil, &ArchiveError{Reason: fmt.Sprintf("found %s where %s should be", hdr.Name, manifest.Tables[i].File)}
+		}
+		err = restoreTable(ctx, tx, t.table, t.columns, manifest.Tables[i], tr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	purgeCaches()
+	return manifest, nil
+}
+
+// readBackupManifest reads the manifest leading the archive.
+func readBackupManifest(tr *tar.Reader) (*BackupManifest, error) {
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, &ArchiveError{Reason: "not a tar archive: " + err.Error()}
+	}
+	if hdr.Name != backupManifestFile {
+		return nil, &ArchiveError{Reason: "does not start with " + backupManifestFile}
+	}
+	var manifest BackupManifest
+	err = json.NewDecoder(io.LimitReader(tr, 1<<20)).Decode(&manifest)
+	if err != nil {
+		return nil, &ArchiveError{Reason: backupManifestFile + ": " + err.Error()}
+	}
+	if manifest.Format != BackupFormat {
+		return nil, &ArchiveError{Reason: fmt.Sprintf("format %q is not %q", manifest.Format, BackupFormat)}
+	}
+	if manifest.FormatVersion != BackupFormatVersion {
+		return nil, &ArchiveError{Reason: fmt.Sprintf("format version %d cannot be read, only version %d",
+			manifest.FormatVersion, BackupFormatVersion)}
+	}
+	return &manifest, nil
+}
+
+// checkBackupTables makes sure the archive holds the tables and columns
+// this version of the code restores.
+func checkBackupTables(manifest *BackupManifest) error {
+	if len(manifest.Tables) != len(backupColumns) {
+		return &ArchiveError{Reason: fmt.Sprintf("holds %d tables, not %d", len(manifest.Tables), len(backupColumns))}
+	}
+	for i, t := range backupColumns {
+		got := manifest.Tables[i]
+		if got.Name != t.table || !slices.Equal(got.Columns, backupColumnNames(t.columns)) {
+			return &ArchiveError{Reason: fmt.Sprintf("table %d is %s(%s), not %s(%s)", i+1,
+				got.Name, strings.Join(got.Columns, ", "), t.table, strings.Join(backupColumnNames(t.columns), ", "))}
+		}
+	}
+	return nil
+}
+
+// restoreTable copies one file of the archive into a temporary table,
+// checks it against the manifest and only then moves the rows over,
+// keeping their ids.
+func restoreTable(ctx context.Context, tx pgx.Tx, table string, columns []backupColumn, want BackupTable, r io.Reader) error {
+	names := backupColumnNames(columns)
+	list := strings.Join(names, ", ")
+	temp := "restore_" + table
+	_, err := tx.Exec(ctx, `CREATE TEMP TABLE `+temp+` ON COMMIT DROP AS
+		SELECT `+list+` FROM `+table+` WITH NO DATA`)
+	if err != nil {
+		return err
+	}
+
+	// A failing source only reaches the server as a CopyFail, so the
+	// PgError CopyFrom returns says nothing about the file. The rest of the
+	// file is read and checked first, so damage is reported as such.
+	sum := sha256.New()
+	src := newRestoreSource(io.TeeReader(r, sum), want.File, columns)
+	_, copyErr := tx.CopyFrom(ctx, pgx.Identifier{temp}, names, src)
+	if _, err = io.Copy(sum, r); err != nil {
+		return &ArchiveError{Reason: want.File + ": " + err.Error()}
+	}
+	if got := hex.EncodeToString(sum.Sum(nil)); got != want.SHA256 {
+		return &ArchiveError{Reason: fmt.Sprintf("%s is damaged: its checksum is %s, not %s", want.File, got, want.SHA256)}
+	}
+	if err = src.Err(); err != nil {
+		return err
+	}
+	if copyErr != nil {
+		return copyErr
+	}
+	if src.rows != want.Rows {
+		return &ArchiveError{Reason: fmt.Sprintf("%s holds %d rows, not %d", want.File, src.rows, want.Rows)}
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO `+table+` (`+list+`) OVERRIDING SYSTEM VALUE
+		SELECT `+list+` FROM `+temp)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `SELECT setval(pg_get_serial_sequence($1, 'id'), COALESCE(max(id), 0) + 1, false) FROM `+table, table)
+	return err
+}
+
+// restoreSource feeds the rows of a backup file to CopyFrom.
+type restoreSource struct {
+	scanner *bufio.Scanner
+	file    string
+	columns []backupColumn
+	values  []any
+	rows    int
+	err     error
+}
+
+func newRestoreSource(r io.Reader, file string, columns []backupColumn) *restoreSource {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &restoreSource{scanner: scanner, file: file, columns: columns, values: make([]any, len(columns))}
+}
+
+func (s *restoreSource) Next() bool {
+	if s.err != nil || !s.scanner.Scan() {
+		return false
+	}
+	s.rows++
+	s.err = s.decode(s.scanner.Bytes())
+	return s.err == nil
+}
+
+func (s *restoreSource) decode(line []byte) error {
+	fail := func(reason string) error {
+		return &ArchiveError{Reason: fmt.Sprintf("%s line %d: %s", s.file, s.rows, reason)}
+	}
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	var raw []any
+	if err := dec.Decode(&raw); err != nil {
+		return fail(err.Error())
+	}
+	if len(raw) != len(s.columns) {
+		return fail(fmt.Sprintf("%d values for %d columns", len(raw), len(s.columns)))
+	}
+	for i, c := range s.columns {
+		s.values[i] = nil
+		if raw[i] == nil {
+			continue
+		}
+		var ok bool
+		switch c.kind {
+		case "int":
+			var n json.Number
+			if n, ok = raw[i].(json.Number); ok {
+				v, err := n.Int64()
+				ok = err == nil
+				s.values[i] = v
+			}
+		case "text":
+			s.values[i], ok = raw[i].(string)
+		case "bool":
+			s.values[i], ok = raw[i].(bool)
+		case "time":
+			var text string
+			if text, ok = raw[i].(string); ok {
+				t, err := time.Parse(time.RFC3339Nano, text)
+				ok = err == nil
+				s.values[i] = t
+			}
+		}
+		if !ok {
+			return fail(fmt.Sprintf("%s is not a valid %s", c.name, c.kind))
+		}
+	}
+	return nil
+}
+
+func (s *restoreSource) Values() ([]any, error) {
+	return s.values, nil
+}
+
+func (s *restoreSource) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	if err := s.scanner.Err(); err != nil {
+		return &ArchiveError{Reason: fmt.Sprintf("%s after line %d: %s", s.file, s.rows, err)}
+	}
+	return nil
+}
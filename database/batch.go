@@ -0,0 +1,406 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// BatchOperation is one create, update or delete in a batch. Wherever an
+// id is expected, in ID or in the reference fields of Data, "$name" stands
+// for the id of the record an earlier operation created with Ref "name".
+type BatchOperation struct {
+	Op     string          `json:"op" enums:"create,update,delete"`
+	Entity string          `json:"entity" enums:"director,actor,film,character"`
+	Ref    string          `json:"ref,omitempty"`
+	ID     json.RawMessage `json:"id,omitempty" swaggertype:"string"`
+	Data   json.RawMessage `json:"data,omitempty" swaggertype:"object"`
+}
+
+type BatchResult struct {
+	ID     int
+	Record any
+	Err    error
+}
+
+// BatchInputError is an operation that could not be run as given: an
+// unknown op or entity, bad data, a failed validation or a reference to a
+// record no earlier operation created.
+type BatchInputError struct {
+	Err error
+}
+
+func (e *BatchInputError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *BatchInputError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError tells which operation made an atomic batch fail.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("operation %d: %s", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// batchRecord is implemented by the model types a batch can write.
+type batchRecord interface {
+	insertStatement() (sql string, args []any, dest []any)
+	updateStatement() (sql string, args []any, dest []any)
+	recordID() int
+	setID(id int)
+	// references maps the foreign keys of the record to the
+	// *ReferenceError their violation means.
+	references() map[string]ReferenceError
+}
+
+var batchEntities = map[string]struct {
+	table     string
+	record    func() batchRecord
+	refFields []string
+}{
+	"director":  {"directors", func() batchRecord { return &Director{} }, nil},
+	"actor":     {"actors", func() batchRecord { return &Actor{} }, nil},
+	"film":      {"films", func() batchRecord { return &Film{} }, []string{"directedBy"}},
+	"character": {"characters", func() batchRecord { return &Character{} }, []string{"portrayedBy", "featuredIn"}},
+}
+
+// RunBatch runs ops in order. Atomic batches run in one transaction and
+// stop at the first failure, which is returned as a *BatchError with
+// nothing written. Otherwise every operation commits on its own and its
+// outcome is reported in its result; operations that reference a failed
+// one fail too. validate checks each record before it is written.
+//
+// Atomic batches send consecutive operations that do not depend on each
+// other in one pgx.Batch, so a batch of plain inserts takes one round trip.
+func RunBatch(ctx context.Context, ops []BatchOperation, atomic bool, validate func(any) error) ([]BatchResult, error) {
+	if atomic {
+		return runAtomicBatch(ctx, ops, validate)
+	}
+
+	conn, err := dbpool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	results := make([]BatchResult, len(ops))
+	refs := map[string]int{}
+	for i, op := range ops {
+		step, err := prepareBatchStep(op, refs, validate)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		err = conn.QueryRow(ctx, step.sql, step.args...).Scan(step.dest...)
+		if err != nil {
+			results[i].Err = step.writeErr(err)
+			continue
+		}
+		results[i] = step.result()
+		step.done(refs)
+		if op.Op != "create" {
+			invalidate(batchEntities[op.Entity].table, strconv.Itoa(step.id))
+		}
+	}
+	return results, nil
+}
+
+func runAtomicBatch(ctx context.Context, ops []BatchOperation, validate func(any) error) ([]BatchResult, error) {
+	tx, err := dbpool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	results := make([]BatchResult, len(ops))
+	refs := map[string]int{}
+	for start := 0; start < len(ops); {
+		// A wave ends before the first operation that needs an id created
+		// within it.
+		var steps []*batchStep
+		end := start
+		for ; end < len(ops); end++ {
+			step, err := prepareBatchStep(ops[end], refs, validate)
+			var unresolved *unresolvedRefError
+			if errors.As(err, &unresolved) && unresolved.pending && end > start {
+				break
+			}
+			if err != nil {
+				return nil, &BatchError{Index: end, Err: err}
+			}
+			steps = append(steps, step)
+			if op := ops[end]; op.Op == "create" && op.Ref != "" {
+				refs[op.Ref] = pendingRef
+			}
+		}
+
+		batch := &pgx.Batch{}
+		failed := -1
+		var failedErr error
+		for i, step := range steps {
+			batch.Queue(step.sql, step.args...).QueryRow(func(row pgx.Row) error {
+				err := row.Scan(step.dest...)
+				if err != nil && failed < 0 {
+					failed, failedErr = start+i, step.writeErr(err)
+				}
+				return err
+			})
+		}
+		err = tx.SendBatch(ctx, batch).Close()
+		if err != nil {
+			if failed < 0 {
+				return nil, err
+			}
+			return nil, &BatchError{Index: failed, Err: failedErr}
+		}
+		for i, step := range steps {
+			results[start+i] = step.result()
+			step.done(refs)
+		}
+		start = end
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i, op := range ops {
+		if op.Op != "create" {
+			invalidate(batchEntities[op.Entity].table, strconv.Itoa(results[i].ID))
+		}
+	}
+	return results, nil
+}
+
+// pendingRef marks a ref whose id will only be known once the current wave
+// has run.
+const pendingRef = -1
+
+type unresolvedRefError struct {
+	ref     string
+	pending bool
+}
+
+func (e *unresolvedRefError) Error() string {
+	return fmt.Sprintf("no earlier operation created $%s", e.ref)
+}
+
+type batchStep struct {
+	op     BatchOperation
+	record batchRecord
+	id     int
+	sql    string
+	args   []any
+	dest   []any
+}
+
+func prepareBatchStep(op BatchOperation, refs map[string]int, validate func(any) error) (*batchStep, error) {
+	entity, ok := batchEntities[op.Entity]
+	if !ok {
+		return nil, &BatchInputError{fmt.Errorf("unknown entity %q", op.Entity)}
+	}
+	step := &batchStep{op: op}
+
+	if op.Op == "update" || op.Op == "delete" {
+		id, err := resolveID(op.ID, refs)
+		if err != nil {
+			return nil, err
+		}
+		step.id = id
+	}
+
+	switch op.Op {
+	case "create", "update":
+		data, err := resolveRefFields(op.Data, entity.refFields, refs)
+		if err != nil {
+			return nil, err
+		}
+		step.record = entity.record()
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(step.record); err != nil {
+			return nil, &BatchInputError{fmt.Errorf("data: %w", err)}
+		}
+		if op.Op == "create" {
+			step.sql, step.args, step.dest = step.record.insertStatement()
+		} else {
+			step.record.setID(step.id)
+			step.sql, step.args, step.dest = step.record.updateStatement()
+		}
+		if err := validate(step.record); err != nil {
+			return nil, &BatchInputError{err}
+		}
+	case "delete":
+		step.sql = `DELETE FROM ` + entity.table + ` WHERE id = $1 RETURNING id`
+		step.args = []any{step.id}
+		step.dest = []any{&step.id}
+	default:
+		return nil, &BatchInputError{fmt.Errorf("unknown op %q, want create, update or delete", op.Op)}
+	}
+	return step, nil
+}
+
+func (s *batchStep) result() BatchResult {
+	if s.op.Op == "delete" {
+		return BatchResult{ID: s.id}
+	}
+	return BatchResult{ID: s.record.recordID(), Record: s.record}
+}
+
+// writeErr turns the foreign key violation of a create or update into a
+// *ReferenceError, as the plain handlers report it. A violation by a
+// delete means the row is still referenced and is returned unchanged.
+func (s *batchStep) writeErr(err error) error {
+	if s.record == nil {
+		return err
+	}
+	return asReferenceError(err, s.record.references())
+}
+
+// done records the id of a created record under its ref.
+func (s *batchStep) done(refs map[string]int) {
+	if s.op.Op == "create" && s.op.Ref != "" {
+		refs[s.op.Ref] = s.result().ID
+	}
+}
+
+// resolveID reads an id given as a number or a "$ref".
+func resolveID(raw json.RawMessage, refs map[string]int) (int, error) {
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if len(raw) == 0 || dec.Decode(&v) != nil {
+		return 0, &BatchInputError{errors.New("id is required")}
+	}
+	return resolveValue("id", v, refs)
+}
+
+func resolveValue(field string, v any, refs map[string]int) (int, error) {
+	switch v := v.(type) {
+	case json.Number:
+		id, err := strconv.Atoi(v.String())
+		if err != nil {
+			return 0, &BatchInputError{fmt.Errorf("%s must be an integer", field)}
+		}
+		return id, nil
+	case string:
+		ref, ok := strings.CutPrefix(v, "$")
+		if !ok {
+			return 0, &BatchInputError{fmt.Errorf("%s must be an integer or a $ref", field)}
+		}
+		id, ok := refs[ref]
+		if !ok || id == pendingRef {
+			return 0, &BatchInputError{&unresolvedRefError{ref: ref, pending: ok}}
+		}
+		return id, nil
+	}
+	return 0, &BatchInputError{fmt.Errorf("%s must be an integer or a $ref", field)}
+}
+
+// resolveRefFields replaces "$ref" values of the given fields with ids.
+func resolveRefFields(data json.RawMessage, fields []string, refs map[string]int) (json.RawMessage, error) {
+	if len(data) == 0 {
+		return nil, &BatchInputError{errors.New("data is required")}
+	}
+	if len(fields) == 0 {
+		return data, nil
+	}
+	var obj map[string]any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&obj); err != nil {
+		return nil, &BatchInputError{fmt.Errorf("data: %w", err)}
+	}
+	for _, field := range fields {
+		v, ok := obj[field]
+		if !ok {
+			continue
+		}
+		id, err := resolveValue(field, v, refs)
+		if err != nil {
+			return nil, err
+		}
+		obj[field] = id
+	}
+	return json.Marshal(obj)
+}
+
+func (d *Director) insertStatement() (string, []any, []any) {
+	return `INSERT INTO directors (first_name, middle_name, last_name) VALUES ($1, $2, $3) RETURNING id, updated_at`,
+		[]any{d.FirstName, d.MiddleName, d.LastName}, []any{&d.ID, &d.UpdatedAt}
+}
+
+func (d *Director) updateStatement() (string, []any, []any) {
+	return `UPDATE directors SET first_name=$1, middle_name=$2, last_name=$3 WHERE id = $4 RETURNING updated_at`,
+		[]any{d.FirstName, d.MiddleName, d.LastName, d.ID}, []any{&d.UpdatedAt}
+}
+
+func (d *Director) recordID() int { return d.ID }
+
+func (d *Director) setID(id int) { d.ID = id }
+
+func (d *Director) references() map[string]ReferenceError { return nil }
+
+func (a *Actor) insertStatement() (string, []any, []any) {
+	return `INSERT INTO actors (first_name, middle_name, last_name) VALUES ($1, $2, $3) RETURNING id, updated_at`,
+		[]any{a.FirstName, a.MiddleName, a.LastName}, []any{&a.ID, &a.UpdatedAt}
+}
+
+func (a *Actor) updateStatement() (string, []any, []any) {
+	return `UPDATE actors SET first_name=$1, middle_name=$2, last_name=$3 WHERE id = $4 RETURNING updated_at`,
+		[]any{a.FirstName, a.MiddleName, a.LastName, a.ID}, []any{&a.UpdatedAt}
+}
+
+func (a *Actor) recordID() int { return a.ID }
+
+func (a *Actor) setID(id int) { a.ID = id }
+
+func (a *Actor) references() map[string]ReferenceError { return nil }
+
+func (f *Film) insertStatement() (string, []any, []any) {
+	return `INSERT INTO films (title, directed_by, logline, year) VALUES ($1, $2, $3, $4) RETURNING id, updated_at`,
+		[]any{f.Title, f.DirectedBy, f.Logline, f.Year}, []any{&f.ID, &f.UpdatedAt}
+}
+
+func (f *Film) updateStatement() (string, []any, []any) {
+	return `UPDATE films SET title=$1, directed_by=$2, logline=$3, year=$4 WHERE id = $5 RETURNING updated_at`,
+		[]any{f.Title, f.DirectedBy, f.Logline, f.Year, f.ID}, []any{&f.UpdatedAt}
+}
+
+func (f *Film) recordID() int { return f.ID }
+
+func (f *Film) setID(id int) { f.ID = id }
+
+func (f *Film) references() map[string]ReferenceError { return filmReferences(*f) }
+
+func (c *Character) insertStatement() (string, []any, []any) {
+	return `INSERT INTO characters (name, portrayed_by, featured_in, dies_in_the_end) VALUES ($1, $2, $3, $4) RETURNING id, updated_at`,
+		[]any{c.Name, c.PortrayedBy, c.FeaturedIn, c.DiesInTheEnd}, []any{&c.ID, &c.UpdatedAt}
+}
+
+func (c *Character) updateStatement() (string, []any, []any) {
+	return `UPDATE characters SET name=$1, portrayed_by=$2, featured_in=$3, dies_in_the_end=$4 WHERE id = $5 RETURNING updated_at`,
+		[]any{c.Name, c.PortrayedBy, c.FeaturedIn, c.DiesInTheEnd, c.ID}, []any{&c.UpdatedAt}
+}
+
+func (c *Character) recordID() int { return c.ID }
+
+func (c *Character) setID(id int) { c.ID = id }
+
+func (c *Character) references() map[string]ReferenceError { return characterReferences(*c) }
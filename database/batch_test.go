@@ -0,0 +1,183 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func noValidation(any) error { return nil }
+
+func TestPrepareBatchStepResolvesRefs(t *testing.T) {
+	refs := map[string]int{"heat": 7, "mann": 3, "pacino": 11}
+	for _, tt := range []struct {
+		name string
+		op   BatchOperation
+		args []any
+	}{
+		{
+			name: "create with a ref field",
+			op: BatchOperation{Op: "create", Entity: "film",
+				Data: json.RawMessage(`{"title":"Heat","directedBy":"$mann","logline":"Cops and robbers","year":1995}`)},
+			args: []any{"Heat", 3, "Cops and robbers", 1995},
+		},
+		{
+			name: "create with plain ids",
+			op: BatchOperation{Op: "create", Entity: "character",
+				Data: json.RawMessage(`{"name":"Vincent Hanna","portrayedBy":11,"featuredIn":"$heat"}`)},
+			args: []any{"Vincent Hanna", 11, 7, false},
+		},
+		{
+			name: "update by ref",
+			op: BatchOperation{Op: "update", Entity: "film", ID: json.RawMessage(`"$heat"`),
+				Data: json.RawMessage(`{"title":"Heat","directedBy":3,"logline":"Cops and robbers","year":1995}`)},
+			args: []any{"Heat", 3, "Cops and robbers", 1995, 7},
+		},
+		{
+			name: "delete by id",
+			op:   BatchOperation{Op: "delete", Entity: "actor", ID: json.RawMessage(`12`)},
+			args: []any{12},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			step, err := prepareBatchStep(tt.op, refs, noValidation)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := json.Marshal(step.args)
+			want, _ := json.Marshal(tt.args)
+			if string(got) != string(want) {
+				t.Errorf("args %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestPrepareBatchStepRejects(t *testing.T) {
+	refs := map[string]int{"heat": 7, "wave": pendingRef}
+	for _, tt := range []struct {
+		name    string
+		op      BatchOperation
+		want    string
+		pending bool
+	}{
+		{
+			name: "unknown ref",
+			op:   BatchOperation{Op: "delete", Entity: "film", ID: json.RawMessage(`"$ronin"`)},
+			want: "no earlier operation created $ronin",
+		},
+		{
+			name:    "ref created in the same wave",
+			op:      BatchOperation{Op: "delete", Entity: "film", ID: json.RawMessage(`"$wave"`)},
+			want:    "no earlier operation created $wave",
+			pending: true,
+		},
+		{
+			name: "ref field without a dollar",
+			op: BatchOperation{Op: "create", Entity: "film",
+				Data: json.RawMessage(`{"title":"Heat","directedBy":"mann"}`)},
+			want: "directedBy must be an integer or a $ref",
+		},
+		{
+			name: "fractional id",
+			op:   BatchOperation{Op: "delete", Entity: "film", ID: json.RawMessage(`1.5`)},
+			want: "id must be an integer",
+		},
+		{
+			name: "missing id",
+			op:   BatchOperation{Op: "update", Entity: "film", Data: json.RawMessage(`{}`)},
+			want: "id is required",
+		},
+		{
+			name: "missing data",
+			op:   BatchOperation{Op: "create", Entity: "director"},
+			want: "data is required",
+		},
+		{
+			name: "unknown field",
+			op:   BatchOperation{Op: "create", Entity: "director", Data: json.RawMessage(`{"name":"Michael Mann"}`)},
+			want: `unknown field "name"`,
+		},
+		{
+			name: "unknown entity",
+			op:   BatchOperation{Op: "create", Entity: "studio", Data: json.RawMessage(`{}`)},
+			want: `unknown entity "studio"`,
+		},
+		{
+			name: "unknown op",
+			op:   BatchOperation{Op: "upsert", Entity: "film", Data: json.RawMessage(`{}`)},
+			want: `unknown op "upsert"`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := prepareBatchStep(tt.op, refs, noValidation)
+			var inputErr *BatchInputError
+			if !errors.As(err, &inputErr) || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("prepareBatchStep: %v, want a BatchInputError containing %q", err, tt.want)
+			}
+			var unresolved *unresolvedRefError
+			if errors.As(err, &unresolved) && unresolved.pending != tt.pending {
+				t.Errorf("pending = %v, want %v", unresolved.pending, tt.pending)
+			}
+		})
+	}
+}
+
+func TestPrepareBatchStepValidates(t *testing.T) {
+	invalid := errors.New("year is out of range")
+	op := BatchOperation{Op: "create", Entity: "film", Data: json.RawMessage(`{"title":"Heat","year":1800}`)}
+	_, err := prepareBatchStep(op, nil, func(any) error { return invalid })
+	var inputErr *BatchInputError
+	if !errors.As(err, &inputErr) || !errors.Is(err, invalid) {
+		t.Errorf("prepareBatchStep: %v, want the validation error as a BatchInputError", err)
+	}
+}
+
+func TestBatchStepWriteErr(t *testing.T) {
+	fk := func(constraint string) error {
+		return &pgconn.PgError{Code: "23503", ConstraintName: constraint}
+	}
+	for _, tt := range []struct {
+		name string
+		op   BatchOperation
+		err  error
+		want *ReferenceError
+	}{
+		{
+			name: "film naming a missing director",
+			op:   BatchOperation{Op: "create", Entity: "film", Data: json.RawMessage(`{"directedBy":3}`)},
+			err:  fk("films_directed_by_fkey"),
+			want: &ReferenceError{Entity: "director", ID: 3},
+		},
+		{
+			name: "character naming a missing film",
+			op: BatchOperation{Op: "update", Entity: "character", ID: json.RawMessage(`5`),
+				Data: json.RawMessage(`{"portrayedBy":11,"featuredIn":7}`)},
+			err:  fk("characters_featured_in_fkey"),
+			want: &ReferenceError{Entity: "film", ID: 7},
+		},
+		{
+			name: "delete of a referenced record",
+			op:   BatchOperation{Op: "delete", Entity: "director", ID: json.RawMessage(`3`)},
+			err:  fk("films_directed_by_fkey"),
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			step, err := prepareBatchStep(tt.op, nil, noValidation)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = step.writeErr(tt.err)
+			var refErr *ReferenceError
+			switch {
+			case tt.want == nil && errors.As(err, &refErr):
+				t.Errorf("writeErr = %v, want the foreign key violation unchanged", err)
+			case tt.want != nil && (!errors.As(err, &refErr) || *refErr != *tt.want):
+				t.Errorf("writeErr = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package database
+
+import (
+	"context"
+	"go-test/cache"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// CacheConfig sizes the read-through cache of single-entity lookups. A
+// size of zero disables it.
+type CacheConfig struct {
+	// Size bounds the entries kept per entity type.
+	Size int
+	TTL  time.Duration
+}
+
+// invalidationChannel carries "<table>:<id>" payloads, sent by the triggers
+// of migration 004 whenever a row is updated or deleted, and "<table>:*"
+// when a table is truncated.
+const invalidationChannel = "cache_invalidation"
+
+// cacheLoadTimeout bounds a lookup shared by concurrent misses, which no
+// single caller's deadline applies to.
+const cacheLoadTimeout = 10 * time.Second
+
+var (
+	directorCache  *entityCache[Director]
+	actorCache     *entityCache[Actor]
+	filmCache      *entityCache[Film]
+	characterCache *entityCache[Character]
+)
+
+// SetupCache enables the cache and starts listening for invalidations from
+// other replicas until ctx is done. Call it after SetupDB.
+func SetupCache(ctx context.Context, cfg CacheConfig) {
+	if cfg.Size <= 0 {
+		return
+	}
+	directorCache = newEntityCache[Director](cfg)
+	actorCache = newEntityCache[Actor](cfg)
+	filmCache = newEntityCache[Film](cfg)
+	characterCache = newEntityCache[Character](cfg)
+	go listenForInvalidations(ctx)
+}
+
+// CacheStats reports hits, misses and evictions by entity. It is empty
+// while the cache is disabled.
+func CacheStats() map[string]cache.Stats {
+	stats := map[string]cache.Stats{}
+	if directorCache != nil {
+		stats["director"] = directorCache.lru.Stats()
+		stats["actor"] = actorCache.lru.Stats()
+		stats["film"] = filmCache.lru.Stats()
+		stats["character"] = characterCache.lru.Stats()
+	}
+	return stats
+}
+
+func FindFirstDirector(ctx context.Context, id string) (*Director, error) {
+	return directorCache.get(ctx, id, findFirstDirector)
+}
+
+func FindFirstActor(ctx context.Context, id string) (*Actor, error) {
+	return actorCache.get(ctx, id, findFirstActor)
+}
+
+func FindFirstFilm(ctx context.Context, id string) (*Film, error) {
+	return filmCache.get(ctx, id, findFirstFilm)
+}
+
+func FindFirstCharacter(ctx context.Context, id string) (*Character, error) {
+	return characterCache.get(ctx, id, findFirstCharacter)
+}
+
+type entityCache[V any] struct {
+	lru   *cache.LRU[int, V]
+	group singleflight.Group
+	// generation changes on every invalidation, so that a load that raced
+	// with one does not store what it read.
+	generation atomic.Uint64
+}
+
+func newEntityCache[V any](cfg CacheConfig) *entityCache[V] {
+	return &entityCache[V]{lru: cache.New[int, V](cfg.Size, cfg.TTL)}
+}
+
+// get returns a copy of the cached entity, loading it on a miss. Concurrent
+// misses for the same id share one query. Lookups that fail, including
+// ones that find nothing, are not cached.
+func (c *entityCache[V]) get(ctx context.Context, id string, load func(context.Context, string) (*V, error)) (*V, error) {
+	key, err := strconv.Atoi(id)
+	if c == nil || err != nil {
+		return load(ctx, id)
+	}
+	if v, ok := c.lru.Get(key); ok {
+		return &v, nil
+	}
+
+	// The shared load must not end with the request that happened to
+	// start it, so it gets its own deadline and each caller waits only as
+	// long as its own context allows.
+	generation := c.generation.Load()
+	ch := c.group.DoChan(strconv.Itoa(key), func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cacheLoadTimeout)
+		defer cancel()
+		found, err := load(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if c.generation.Load() == generation {
+			c.lru.Add(key, *found)
+		}
+		return *found, nil
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		value := res.Val.(V)
+		return &value, nil
+	}
+}
+
+func (c *entityCache[V]) invalidate(id string) {
+	if c == nil {
+		return
+	}
+	c.generation.Add(1)
+	if id == "*" {
+		c.lru.Purge()
+		return
+	}
+	if key, err := strconv.Atoi(id); err == nil {
+		c.lru.Remove(key)
+	}
+}
+
+// invalidate drops a row from this process's cache. Other replicas learn
+// of the change through the triggers' notifications.
+func invalidate(table, id string) {
+	switch table {
+	case "directors":
+		directorCache.invalidate(id)
+	case "actors":
+		actorCache.invalidate(id)
+	case "films":
+		filmCache.invalidate(id)
+	case "characters":
+		characterCache.invalidate(id)
+	}
+}
+
+func purgeCaches() {
+	for _, table := range []string{"directors", "actors", "films", "characters"} {
+		invalidate(table, "*")
+	}
+}
+
+// listenForInvalidations keeps a connection listening on the invalidation
+// channel, reconnecting with backoff. Notifications sent while it was
+// disconnected are lost, so it empties the caches on every reconnect.
+func listenForInvalidations(ctx context.Context) {
+	backoff := time.Second
+	for {
+		err := listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Warn("Cache invalidation listener disconnected", "err", err, "retryIn", backoff)
+		purgeCaches()
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+func listen(ctx context.Context) error {
+	pooled, err := dbpool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// The connection stays in LISTEN mode, so it must not go back to the
+	// pool.
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err = conn.Exec(ctx, "LISTEN "+invalidationChannel); err != nil {
+		return err
+	}
+	purgeCaches()
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		table, id, ok := strings.Cut(n.Payload, ":")
+		if ok {
+			invalidate(table, id)
+		}
+	}
+}
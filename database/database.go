@@ -2,17 +2,190 @@ package database
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"go-test/logging"
+	"log/slog"
+	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/multitracer"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/tracelog"
 )
 
 var dbpool *pgxpool.Pool
 
-func SetupDB(dsn string) {
-	var err error
-	dbpool, err = pgxpool.New(context.Background(), dsn)
+// PoolConfig tunes the connection pool. Zero values keep the pgx defaults.
+type PoolConfig struct {
+	DSN               string
+	MaxConns          int32
+	MinConns          int32
+	MaxConnLifetime   time.Duration
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+	// StatementCacheMode is one of the pgx query exec modes: cache_statement,
+	// cache_describe, describe_exec, exec or simple_protocol. The last two
+	// work behind PgBouncer in transaction mode.
+	StatementCacheMode string
+	ApplicationName    string
+	SearchPath         string
+	// ConnectAttempts bounds how often SetupDB pings before giving up.
+	ConnectAttempts int
+	// QueryLogLevel is the least severe pgx trace level that is logged:
+	// trace, debug, info, warn, error or none. Successful queries are
+	// logged at info. Empty means warn, which logs only failed queries.
+	QueryLogLevel string
+	// MigrationsTable is the table tern records the schema version in, as
+	// passed to tern migrate --version-table. Empty means schema_migrations.
+	MigrationsTable string
+}
+
+var queryExecModes = map[string]pgx.QueryExecMode{
+	"cache_statement": pgx.QueryExecModeCacheStatement,
+	"cache_describe":  pgx.QueryExecModeCacheDescribe,
+	"describe_exec":   pgx.QueryExecModeDescribeExec,
+	"exec":            pgx.QueryExecModeExec,
+	"simple_protocol": pgx.QueryExecModeSimpleProtocol,
+}
+
+// SetupDB creates the pool and pings the database until it answers, backing
+// off between attempts, so that a bad DSN or an unreachable server fails
+// startup instead of the first request.
+func SetupDB(ctx context.Context, cfg PoolConfig) error {
+	config, err := pgxpool.ParseConfig(cfg.DSN)
 	if err != nil {
-		log.Fatalf("Unable to connect to database: %v\n", err)
+		return err
+	}
+	if cfg.MaxConns > 0 {
+		config.MaxConns = cfg.MaxConns
+	}
+	if cfg.MinConns > 0 {
+		config.MinConns = cfg.MinConns
+	}
+	if cfg.MaxConnLifetime > 0 {
+		config.MaxConnLifetime = cfg.MaxConnLifetime
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		config.MaxConnIdleTime = cfg.MaxConnIdleTime
+	}
+	if cfg.HealthCheckPeriod > 0 {
+		config.HealthCheckPeriod = cfg.HealthCheckPeriod
+	}
+	if cfg.StatementCacheMode != "" {
+		mode, ok := queryExecModes[cfg.StatementCacheMode]
+		if !ok {
+			return fmt.Errorf("unknown statement cache mode %q", cfg.StatementCacheMode)
+		}
+		config.ConnConfig.DefaultQueryExecMode = mode
+	}
+	config.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		_, err := conn.Exec(ctx,
+			`SELECT set_config('application_name', $1, false),
+			set_config('search_path', COALESCE(NULLIF($2, ''), current_setting('search_path')), false)`,
+			cfg.ApplicationName, cfg.SearchPath,
+		)
+		return err
+	}
+	logLevel := tracelog.LogLevelWarn
+	if cfg.QueryLogLevel != "" {
+		logLevel, err = tracelog.LogLevelFromString(cfg.QueryLogLevel)
+		if err != nil {
+			return fmt.Errorf("query log level %q: %w", cfg.QueryLogLevel, err)
+		}
+	}
+	config.ConnConfig.Tracer = multitracer.New(
+		&tracelog.TraceLog{
+			Logger:   tracelog.LoggerFunc(logQuery),
+			LogLevel: logLevel,
+		},
+		queryTracer{},
+	)
+
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	attempts := max(cfg.ConnectAttempts, 1)
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err = pool.Ping(pingCtx)
+		cancel()
+		if err == nil {
+			break
+		}
+		if attempt == attempts {
+			pool.Close()
+			return fmt.Errorf("database unreachable after %d attempts: %w", attempts, err)
+		}
+		slog.Warn("Database not ready, retrying", "attempt", attempt, "backoff", backoff, "err", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			pool.Close()
+			return ctx.Err()
+		}
+		backoff = min(backoff*2, 10*time.Second)
 	}
+
+	dbpool = pool
+	if cfg.MigrationsTable != "" {
+		migrationsTable = cfg.MigrationsTable
+	}
+	return nil
+}
+
+// logQuery logs through the logger in ctx, so query logs carry the fields of
+// the request that issued them. Query arguments are replaced by their count,
+// as they hold session ids, API keys and personal data.
+func logQuery(ctx context.Context, level tracelog.LogLevel, msg string, data map[string]any) {
+	var slogLevel slog.Level
+	switch level {
+	case tracelog.LogLevelTrace, tracelog.LogLevelDebug:
+		slogLevel = slog.LevelDebug
+	case tracelog.LogLevelInfo:
+		slogLevel = slog.LevelInfo
+	case tracelog.LogLevelWarn:
+		slogLevel = slog.LevelWarn
+	default:
+		slogLevel = slog.LevelError
+	}
+
+	logger := logging.FromContext(ctx)
+	if !logger.Enabled(ctx, slogLevel) {
+		return
+	}
+	attrs := make([]slog.Attr, 0, len(data))
+	for k, v := range data {
+		if args, ok := v.([]any); ok && k == "args" {
+			v = fmt.Sprintf("%d redacted", len(args))
+		}
+		attrs = append(attrs, slog.Any(k, v))
+	}
+	logger.LogAttrs(ctx, slogLevel, "database: "+msg, attrs...)
+}
+
+// Stat returns the pool statistics, or nil before SetupDB.
+func Stat() *pgxpool.Stat {
+	if dbpool == nil {
+		return nil
+	}
+	return dbpool.Stat()
+}
+
+// Close waits for acquired connections to be released and closes the pool.
+func Close() {
+	if dbpool != nil {
+		dbpool.Close()
+	}
+}
+
+// querier is what pooled connections and transactions have in common, so
+// that the same statements can run on either.
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
 }
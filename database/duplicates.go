@@ -0,0 +1,196 @@
+package database
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DuplicatePerson is a director or actor in a duplicate candidate.
+type DuplicatePerson struct {
+	ID         int    `json:"id"`
+	FirstName  string `json:"firstName"`
+	MiddleName string `json:"middleName"`
+	LastName   string `json:"lastName"`
+}
+
+// DuplicateCandidate is a pair of records whose normalized names are
+// similar enough that they may be the same person.
+type DuplicateCandidate struct {
+	Records    [2]DuplicatePerson `json:"records"`
+	Similarity float64            `json:"similarity"`
+}
+
+// MergeResult says which records a merge removed and which films or
+// characters now refer to the survivor instead.
+type MergeResult struct {
+	Survivor  int   `json:"survivor"`
+	Merged    []int `json:"merged"`
+	Repointed []int `json:"repointed"`
+}
+
+// mergeTables names, for each entity that can be merged, its table and the
+// column that refers to it.
+var mergeTables = map[string]struct{ table, references, column string }{
+	"director": {"directors", "films", "directed_by"},
+	"actor":    {"actors", "characters", "portrayed_by"},
+}
+
+// FindDuplicateDirectors lists pairs of directors whose names have a
+// trigram similarity of at least threshold, most similar first.
+func FindDuplicateDirectors(ctx context.Context, threshold float64, limit int) ([]DuplicateCandidate, error) {
+	return findDuplicates(ctx, "director", threshold, limit)
+}
+
+// FindDuplicateActors lists pairs of actors like FindDuplicateDirectors.
+func FindDuplicateActors(ctx context.Context, threshold float64, limit int) ([]DuplicateCandidate, error) {
+	return findDuplicates(ctx, "actor", threshold, limit)
+}
+
+// findDuplicates compares the name_key column, which migration 009
+// generates from the first and last names, case folded, without diacritics
+// and transliterated to Latin letters. Its trigram index lets pg_trgm find
+// the similar pairs without comparing every pair.
+func findDuplicates(ctx context.Context, entity string, threshold float64, limit int) ([]DuplicateCandidate, error) {
+	tx, err := dbpool.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `SELECT set_config('pg_trgm.similarity_threshold', $1, true)`,
+		strconv.FormatFloat(threshold, 'f', -1, 64))
+	if err != nil {
+		return nil, err
+	}
+
+	table := mergeTables[entity].table
+	rows, err := tx.Query(ctx,
+		`SELECT a.id, a.first_name, a.middle_name, a.last_name,
+		b.id, b.first_name, b.middle_name, b.last_name,
+		similarity(a.name_key, b.name_key)
+		FROM `+table+` a JOIN `+table+` b ON a.name_key % b.name_key AND a.id < b.id
+		WHERE a.name_key <> ''
+		ORDER BY 9 DESC, 1, 5 LIMIT $1`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	candidates := []DuplicateCandidate{}
+	for rows.Next() {
+		var c DuplicateCandidate
+		a, b := &c.Records[0], &c.Records[1]
+		err = rows.Scan(&a.ID, &a.FirstName, &a.MiddleName, &a.LastName,
+			&b.ID, &b.FirstName, &b.MiddleName, &b.LastName, &c.Similarity)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return candidates, tx.Commit(ctx)
+}
+
+// MergeDirectors merges duplicates into the director survivor; see
+// MergeActors.
+func MergeDirectors(ctx context.Context, survivor int, duplicates []int, by string) (*MergeResult, error) {
+	return mergePeople(ctx, "director", survivor, duplicates, by)
+}
+
+// MergeActors repoints the characters of each duplicate to the actor
+// survivor and deletes the duplicate, recording it in merge_audit, all in
+// one transaction. The survivor takes the external key of the first
+// duplicate that has one if it has none, so a later import of that
+// duplicate's source updates the survivor. It returns pgx.ErrNoRows if the
+// survivor does not exist and a *ReferenceError for a missing duplicate.
+func MergeActors(ctx context.Context, survivor int, duplicates []int, by string) (*MergeResult, error) {
+	return mergePeople(ctx, "actor", survivor, duplicates, by)
+}
+
+func mergePeople(ctx context.Context, entity string, survivor int, duplicates []int, by string) (*MergeResult, error) {
+	m := mergeTables[entity]
+	tx, err := dbpool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Locking in id order makes overlapping merges wait for each other
+	// rather than deadlock.
+	rows, err := tx.Query(ctx, `SELECT id FROM `+m.table+` WHERE id = ANY($1) ORDER BY id FOR UPDATE`,
+		append([]int{survivor}, duplicates...))
+	if err != nil {
+		return nil, err
+	}
+	found, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return nil, err
+	}
+	exists := make(map[int]bool, len(found))
+	for _, id := range found {
+		exists[id] = true
+	}
+	if !exists[survivor] {
+		return nil, pgx.ErrNoRows
+	}
+	for _, id := range duplicates {
+		if !exists[id] {
+			return nil, &ReferenceError{Entity: entity, ID: id}
+		}
+	}
+
+	result := &MergeResult{Survivor: survivor, Merged: duplicates, Repointed: []int{}}
+	for _, duplicate := range duplicates {
+		rows, err = tx.Query(ctx,
+			`UPDATE `+m.references+` SET `+m.column+` = $1 WHERE `+m.column+` = $2 RETURNING id`,
+			survivor, duplicate,
+		)
+		if err != nil {
+			return nil, err
+		}
+		repointed, err := pgx.CollectRows(rows, pgx.RowTo[int])
+		if err != nil {
+			return nil, err
+		}
+		result.Repointed = append(result.Repointed, repointed...)
+
+		var externalKey *string
+		err = tx.QueryRow(ctx,
+			`WITH deleted AS (DELETE FROM `+m.table+` t WHERE id = $3 RETURNING to_jsonb(t) AS row)
+			INSERT INTO merge_audit (entity, survivor_id, merged_id, merged, repointed, merged_by)
+			SELECT $1, $2, $3, row, $4, $5 FROM deleted
+			RETURNING merged->>'external_key'`,
+			entity, survivor, duplicate, repointed, by,
+		).Scan(&externalKey)
+		if err != nil {
+			return nil, err
+		}
+		if externalKey != nil {
+			_, err = tx.Exec(ctx,
+				`UPDATE `+m.table+` SET external_key = $2 WHERE id = $1 AND external_key IS NULL`,
+				survivor, *externalKey,
+			)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	invalidate(m.table, strconv.Itoa(survivor))
+	for _, id := range duplicates {
+		invalidate(m.table, strconv.Itoa(id))
+	}
+	for _, id := range result.Repointed {
+		invalidate(m.references, strconv.Itoa(id))
+	}
+	return result, nil
+}
@@ -0,0 +1,51 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// IsForeignKeyViolation reports whether err was caused by a row still being
+// referenced by another table, e.g. deleting a director who has films.
+func IsForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23503"
+}
+
+// ReferenceError reports an id in a request that names no existing row.
+type ReferenceError struct {
+	Entity string
+	ID     int
+}
+
+// asReferenceError turns the foreign key violation of an insert or update
+// into the *ReferenceError that refs holds for the violated constraint.
+// Other errors are returned unchanged.
+func asReferenceError(err error, refs map[string]ReferenceError) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+		if ref, ok := refs[pgErr.ConstraintName]; ok {
+			return &ref
+		}
+	}
+	return err
+}
+
+func filmReferences(film Film) map[string]ReferenceError {
+	return map[string]ReferenceError{
+		"films_directed_by_fkey": {Entity: "director", ID: film.DirectedBy},
+	}
+}
+
+func characterReferences(character Character) map[string]ReferenceError {
+	return map[string]ReferenceError{
+		"characters_portrayed_by_fkey": {Entity: "actor", ID: character.PortrayedBy},
+		"characters_featured_in_fkey":  {Entity: "film", ID: character.FeaturedIn},
+	}
+}
+
+func (e *ReferenceError) Error() string {
+	return fmt.Sprintf("%s %d does not exist", e.Entity, e.ID)
+}
@@ -0,0 +1,216 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// exportFetchSize is how many rows an export fetches from its cursor at a
+// time, which bounds the memory an export holds however big the table.
+const exportFetchSize = 500
+
+// ExportColumn is a column of an export, named like the API's JSON field.
+// Kind is int, text, bool or time.
+type ExportColumn struct {
+	Field  string
+	Kind   string
+	column string
+}
+
+// ExportEntities lists the entities in foreign key order, so loading the
+// files of a snapshot in this order never refers ahead.
+var ExportEntities = []string{"directors", "actors", "films", "characters"}
+
+var exportColumns = map[string][]ExportColumn{
+	"directors": {
+		{"id", "int", "id"},
+		{"firstName", "text", "first_name"},
+		{"middleName", "text", "middle_name"},
+		{"lastName", "text", "last_name"},
+		{"updatedAt", "time", "updated_at"},
+	},
+	"actors": {
+		{"id", "int", "id"},
+		{"firstName", "text", "first_name"},
+		{"middleName", "text", "middle_name"},
+		{"lastName", "text", "last_name"},
+		{"updatedAt", "time", "updated_at"},
+	},
+	"films": {
+		{"id", "int", "id"},
+		{"title", "text", "title"},
+		{"directedBy", "int", "directed_by"},
+		{"logline", "text", "logline"},
+		{"year", "int", "year"},
+		{"updatedAt", "time", "updated_at"},
+	},
+	"characters": {
+		{"id", "int", "id"},
+		{"name", "text", "name"},
+		{"portrayedBy", "int", "portrayed_by"},
+		{"featuredIn", "int", "featured_in"},
+		{"diesInTheEnd", "bool", "dies_in_the_end"},
+		{"updatedAt", "time", "updated_at"},
+	},
+}
+
+// ExportColumns returns the columns exported for entity.
+func ExportColumns(entity string) ([]ExportColumn, bool) {
+	columns, ok := exportColumns[entity]
+	return columns, ok
+}
+
+// ExportFilter narrows an export to the rows whose fields equal the given
+// values, written as in a query string, and that changed at or after
+// UpdatedSince if set.
+type ExportFilter struct {
+	Fields       map[string]string
+	UpdatedSince time.Time
+}
+
+// FilterError reports a filter on an unknown field or with a value that
+// does not fit the field.
+type FilterError struct {
+	Field  string
+	Reason string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("filter %s: %s", e.Field, e.Reason)
+}
+
+// Exporter reads exports from one read-only transaction.
+type Exporter struct {
+	tx pgx.Tx
+	n  int
+}
+
+// Export streams the rows of entity matching filter to fn, in id order,
+// from a cursor in a read-only transaction. fn gets the values in the
+// order of ExportColumns and must not keep the slice.
+func Export(ctx context.Context, entity string, filter ExportFilter, fn func([]any) error) error {
+	return exportTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(e *Exporter) error {
+		return e.Export(ctx, entity, filter, fn)
+	})
+}
+
+// Snapshot runs fn with an Exporter whose exports all see the same
+// consistent state of the database, taken under REPEATABLE READ.
+func Snapshot(ctx context.Context, fn func(e *Exporter) error) error {
+	return exportTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly}, fn)
+}
+
+func exportTx(ctx context.Context, opts pgx.TxOptions, fn func(e *Exporter) error) error {
+	tx, err := dbpool.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = fn(&Exporter{tx: tx})
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Export works like the package's Export within the snapshot.
+func (e *Exporter) Export(ctx context.Context, entity string, filter ExportFilter, fn func([]any) error) error {
+	columns, ok := exportColumns[entity]
+	if !ok {
+		return fmt.Errorf("export: unknown entity %q", entity)
+	}
+	where, args, err := exportWhere(columns, filter)
+	if err != nil {
+		return err
+	}
+	list := make([]string, len(columns))
+	for i, c := range columns {
+		list[i] = c.column
+	}
+	return e.cursor(ctx, `SELECT `+strings.Join(list, ", ")+` FROM `+entity+where+` ORDER BY id`, args, fn)
+}
+
+// cursor runs query through a cursor, fetching exportFetchSize rows at a
+// time, and calls fn with the values of each row.
+func (e *Exporter) cursor(ctx context.Context, query string, args []any, fn func([]any) error) error {
+	e.n++
+	cursor := "export_" + strconv.Itoa(e.n)
+	_, err := e.tx.Exec(ctx, `DECLARE `+cursor+` NO SCROLL CURSOR FOR `+query, args...)
+	if err != nil {
+		return err
+	}
+	defer e.tx.Exec(context.WithoutCancel(ctx), `CLOSE `+cursor)
+
+	fetch := `FETCH ` + strconv.Itoa(exportFetchSize) + ` FROM ` + cursor
+	for {
+		rows, err := e.tx.Query(ctx, fetch)
+		if err != nil {
+			return err
+		}
+		n := 0
+		for rows.Next() {
+			n++
+			values, err := rows.Values()
+			if err == nil {
+				err = fn(values)
+			}
+			if err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if n < exportFetchSize {
+			return nil
+		}
+	}
+}
+
+func exportWhere(columns []ExportColumn, filter ExportFilter) (string, []any, error) {
+	var conds []string
+	var args []any
+	for field, value := range filter.Fields {
+		var column *ExportColumn
+		for i := range columns {
+			if columns[i].Field == field {
+				column = &columns[i]
+			}
+		}
+		if column == nil || column.Kind == "time" {
+			return "", nil, &FilterError{Field: field, Reason: "cannot filter on this field"}
+		}
+		var arg any = value
+		switch column.Kind {
+		case "int":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return "", nil, &FilterError{Field: field, Reason: fmt.Sprintf("%q is not an integer", value)}
+			}
+			arg = n
+		case "bool":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return "", nil, &FilterError{Field: field, Reason: fmt.Sprintf("%q is not true or false", value)}
+			}
+			arg = b
+		}
+		args = append(args, arg)
+		conds = append(conds, column.column+" = $"+strconv.Itoa(len(args)))
+	}
+	if !filter.UpdatedSince.IsZero() {
+		args = append(args, filter.UpdatedSince)
+		conds = append(conds, "updated_at >= $"+strconv.Itoa(len(args)))
+	}
+	if len(conds) == 0 {
+		return "", nil, nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args, nil
+}
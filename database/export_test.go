@@ -0,0 +1,89 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExportWhere(t *testing.T) {
+	since := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		name   string
+		entity string
+		filter ExportFilter
+		where  string
+		args   []any
+		field  string
+	}{
+		{name: "no filter", entity: "films"},
+		{
+			name:   "int",
+			entity: "films",
+			filter: ExportFilter{Fields: map[string]string{"directedBy": "3"}},
+			where:  " WHERE directed_by = $1",
+			args:   []any{3},
+		},
+		{
+			name:   "text",
+			entity: "directors",
+			filter: ExportFilter{Fields: map[string]string{"lastName": "Mann"}},
+			where:  " WHERE last_name = $1",
+			args:   []any{"Mann"},
+		},
+		{
+			name:   "bool and updated since",
+			entity: "characters",
+			filter: ExportFilter{Fields: map[string]string{"diesInTheEnd": "true"}, UpdatedSince: since},
+			where:  " WHERE dies_in_the_end = $1 AND updated_at >= $2",
+			args:   []any{true, since},
+		},
+		{
+			name:   "unknown field",
+			entity: "films",
+			filter: ExportFilter{Fields: map[string]string{"budget": "1"}},
+			field:  "budget",
+		},
+		{
+			name:   "time field",
+			entity: "films",
+			filter: ExportFilter{Fields: map[string]string{"updatedAt": "2024-05-01"}},
+			field:  "updatedAt",
+		},
+		{
+			name:   "bad int",
+			entity: "films",
+			filter: ExportFilter{Fields: map[string]string{"year": "nineties"}},
+			field:  "year",
+		},
+		{
+			name:   "bad bool",
+			entity: "characters",
+			filter: ExportFilter{Fields: map[string]string{"diesInTheEnd": "maybe"}},
+			field:  "diesInTheEnd",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			where, args, err := exportWhere(exportColumns[tt.entity], tt.filter)
+			if tt.field != "" {
+				var filterErr *FilterError
+				if !errors.As(err, &filterErr) || filterErr.Field != tt.field {
+					t.Fatalf("exportWhere: %v, want a FilterError on %s", err, tt.field)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if where != tt.where {
+				t.Errorf("where %q, want %q", where, tt.where)
+			}
+			got, _ := json.Marshal(args)
+			want, _ := json.Marshal(tt.args)
+			if string(got) != string(want) {
+				t.Errorf("args %s, want %s", got, want)
+			}
+		})
+	}
+}
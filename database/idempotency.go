@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// StoredResponse is the response first given to an idempotent request.
+type StoredResponse struct {
+	Fingerprint []byte
+	Status      int
+	Header      map[string][]string
+	Body        []byte
+}
+
+// IdempotencyKey is a key claimed by a request in progress. Claiming
+// inserts a row with no response yet, so no connection is held while the
+// request runs.
+type IdempotencyKey struct {
+	key string
+}
+
+// ErrIdempotencyKeyInUse is returned by ClaimIdempotencyKey while another
+// request with the key is still in progress.
+var ErrIdempotencyKeyInUse = errors.New("idempotency key is in use by a request in progress")
+
+// ClaimIdempotencyKey claims key for a request with fingerprint. If the key
+// already has a response within window, it returns that instead and no
+// claim. It returns ErrIdempotencyKeyInUse while another request holds the
+// key, unless that claim is older than abandonAfter, which only happens if
+// its server went away without releasing it; the response returned with
+// that error holds only the fingerprint of the other request. The caller
+// must Store or Release a claim.
+func ClaimIdempotencyKey(ctx context.Context, key string, fingerprint []byte, window, abandonAfter time.Duration) (*IdempotencyKey, *StoredResponse, error) {
+	ct, err := dbpool.Exec(ctx,
+		`INSERT INTO idempotency_keys AS k
+		(key, fingerprint)
+		VALUES
+		($1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+		fingerprint = $2, status = NULL, header = NULL, body = NULL, created_at = now()
+		WHERE k.created_at <= now() - make_interval(secs => $3)
+		OR (k.status IS NULL AND k.created_at <= now() - make_interval(secs => $4))`,
+		key, fingerprint, window.Seconds(), abandonAfter.Seconds(),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ct.RowsAffected() == 1 {
+		return &IdempotencyKey{key: key}, nil, nil
+	}
+
+	var resp StoredResponse
+	var status *int
+	err = dbpool.QueryRow(ctx,
+		`SELECT fingerprint, status, header, body FROM idempotency_keys WHERE key = $1`,
+		key,
+	).Scan(&resp.Fingerprint, &status, &resp.Header, &resp.Body)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// The claim was released in between; try again.
+		return ClaimIdempotencyKey(ctx, key, fingerprint, window, abandonAfter)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if status == nil {
+		return nil, &resp, ErrIdempotencyKeyInUse
+	}
+	resp.Status = *status
+	return nil, &resp, nil
+}
+
+// Store records resp as the response for the key.
+func (k *IdempotencyKey) Store(ctx context.Context, resp StoredResponse) error {
+	_, err := dbpool.Exec(ctx,
+		`UPDATE idempotency_keys SET
+		status = $2, header = $3, body = $4, created_at = now()
+		WHERE key = $1`,
+		k.key, resp.Status, resp.Header, resp.Body,
+	)
+	return err
+}
+
+// Release gives up the claim without storing anything, so the request can
+// be retried under the same key.
+func (k *IdempotencyKey) Release(ctx context.Context) error {
+	_, err := dbpool.Exec(ctx, `DELETE FROM idempotency_keys WHERE key = $1 AND status IS NULL`, k.key)
+	return err
+}
+
+// PruneIdempotencyKeys removes responses older than the replay window.
+func PruneIdempotencyKeys(ctx context.Context, window time.Duration) error {
+	_, err := dbpool.Exec(ctx,
+		`DELETE FROM idempotency_keys WHERE created_at < now() - make_interval(secs => $1)`,
+		window.Seconds(),
+	)
+	return err
+}
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ImportRow is a record to load. Rows with an external key replace the row
+// that already has it; rows without one are always inserted.
+type ImportRow struct {
+	ExternalKey string
+	Record      any
+}
+
+// importColumns lists the columns of each entity in the order of the
+// arguments of its insertStatement.
+var importColumns = map[string][]string{
+	"director":  {"first_name", "middle_name", "last_name"},
+	"actor":     {"first_name", "middle_name", "last_name"},
+	"film":      {"title", "directed_by", "logline", "year"},
+	"character": {"name", "portrayed_by", "featured_in", "dies_in_the_end"},
+}
+
+// ImportRows loads rows of entity in one transaction. The rows are copied
+// into a temporary table with COPY and upserted from there in a single
+// statement. Records must be pointers to the entity's model.
+func ImportRows(ctx context.Context, entity string, rows []ImportRow) (inserted, updated int, err error) {
+	columns, ok := importColumns[entity]
+	if !ok {
+		return 0, 0, fmt.Errorf("import: unknown entity %q", entity)
+	}
+	table := batchEntities[entity].table
+	columns = append(columns[:len(columns):len(columns)], "external_key")
+	list := strings.Join(columns, ", ")
+
+	tx, err := dbpool.Begin(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `CREATE TEMP TABLE import_rows ON COMMIT DROP AS
+		SELECT `+list+` FROM `+table+` WITH NO DATA`)
+	if err != nil {
+		return 0, 0, err
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"import_rows"}, columns,
+		pgx.CopyFromSlice(len(rows), func(i int) ([]any, error) {
+			record, ok := rows[i].Record.(batchRecord)
+			if !ok {
+				return nil, fmt.Errorf("import: row %d is a %T, not a %s", i, rows[i].Record, entity)
+			}
+			_, args, _ := record.insertStatement()
+			var key any
+			if rows[i].ExternalKey != "" {
+				key = rows[i].ExternalKey
+			}
+			return append(args, key), nil
+		}),
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	fields := columns[:len(columns)-1]
+	set := make([]string, len(fields))
+	current := make([]string, len(fields))
+	excluded := make([]string, len(fields))
+	for i, column := range fields {
+		set[i] = column + " = EXCLUDED." + column
+		current[i] = "t." + column
+		excluded[i] = "EXCLUDED." + column
+	}
+	// Rows that would not change are left alone, so importing the same
+	// file twice does not touch them. xmax is zero for a freshly inserted
+	// row version and set for one that replaced an existing row.
+	result, err := tx.Query(ctx,
+		`INSERT INTO `+table+` AS t (`+list+`)
+		SELECT `+list+` FROM import_rows
+		ON CONFLICT (external_key) DO UPDATE SET `+strings.Join(set, ", ")+`
+		WHERE (`+strings.Join(current, ", ")+`) IS DISTINCT FROM (`+strings.Join(excluded, ", ")+`)
+		RETURNING xmax = 0`)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer result.Close()
+	for result.Next() {
+		var fresh bool
+		if err = result.Scan(&fresh); err != nil {
+			return 0, 0, err
+		}
+		if fresh {
+			inserted++
+		} else {
+			updated++
+		}
+	}
+	if err = result.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	if updated > 0 {
+		invalidate(table, "*")
+	}
+	return inserted, updated, nil
+}
+
+// ExternalKeyIDs maps those of keys that rows of entity have to their ids.
+func ExternalKeyIDs(ctx context.Context, entity string, keys []string) (map[string]int, error) {
+	e, ok := batchEntities[entity]
+	if !ok {
+		return nil, fmt.Errorf("import: unknown entity %q", entity)
+	}
+	rows, err := dbpool.Query(ctx, `SELECT external_key, id FROM `+e.table+` WHERE external_key = ANY($1)`, keys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make(map[string]int, len(keys))
+	for rows.Next() {
+		var key string
+		var id int
+		if err = rows.Scan(&key, &id); err != nil {
+			return nil, err
+		}
+		ids[key] = id
+	}
+	return ids, rows.Err()
+}
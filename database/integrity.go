@@ -0,0 +1,301 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// maxFindings bounds the findings reported per rule.
+const maxFindings = 1000
+
+// IntegrityFinding is a problem with one row, or a group of rows that are
+// only a problem together such as duplicates.
+type IntegrityFinding struct {
+	Table  string `json:"table"`
+	IDs    []int  `json:"ids"`
+	Detail string `json:"detail"`
+}
+
+type IntegrityResult struct {
+	Rule        string `json:"rule"`
+	Description string `json:"description"`
+	// Status is passed, failed, fixed or skipped.
+	Status    string             `json:"status"`
+	Reason    string             `json:"reason,omitempty"`
+	Fixable   bool               `json:"fixable"`
+	Fixed     int                `json:"fixed,omitempty"`
+	Findings  []IntegrityFinding `json:"findings,omitempty"`
+	Truncated bool               `json:"truncated,omitempty"`
+}
+
+type IntegrityReport struct {
+	Results []IntegrityResult `json:"results"`
+	// Failed counts the rules with findings left.
+	Failed int `json:"failed"`
+}
+
+// integrityRule finds rows breaking a rule. Its query selects the table,
+// the ids and a description of each finding. A rule with fix statements is
+// safe to repair without a human deciding how.
+type integrityRule struct {
+	name        string
+	description string
+	query       string
+	fix         []string
+	// skip says why the rule cannot run yet.
+	skip string
+}
+
+var integrityRules = []integrityRule{
+	{
+		name:        "film_year_range",
+		description: "Film years satisfy the model's validation tag",
+		query:       filmYearQuery(),
+	},
+	{
+		name:        "actor_born_after_film",
+		description: "Characters are not portrayed by actors born after the film",
+		skip:        "actors have no birth date yet",
+	},
+	{
+		name:        "duplicate_film_titles",
+		description: "No two films share a title in the same year",
+		query: `SELECT 'films', array_agg(id ORDER BY id),
+			format('%s (%s) appears %s times', min(title), year, count(*))
+			FROM films GROUP BY lower(btrim(title)), year HAVING count(*) > 1`,
+	},
+	{
+		name:        "blank_names",
+		description: "Required names, titles and loglines are not blank",
+		query: `SELECT 'directors', ARRAY[id], 'first or last name is blank' FROM directors
+				WHERE btrim(first_name) = '' OR btrim(last_name) = ''
+			UNION ALL SELECT 'actors', ARRAY[id], 'first or last name is blank' FROM actors
+				WHERE btrim(first_name) = '' OR btrim(last_name) = ''
+			UNION ALL SELECT 'films', ARRAY[id], 'title or logline is blank' FROM films
+				WHERE btrim(title) = '' OR btrim(logline) = ''
+			UNION ALL SELECT 'characters', ARRAY[id], 'name is blank' FROM characters
+				WHERE btrim(name) = ''`,
+	},
+	{
+		name:        "duplicate_people",
+		description: "No two directors or actors have the same name",
+		query: `SELECT 'directors', array_agg(id ORDER BY id),
+				format('%s appears %s times', concat_ws(' ', min(first_name), min(NULLIF(middle_name, '')), min(last_name)), count(*))
+				FROM directors GROUP BY lower(btrim(first_name)), lower(btrim(COALESCE(middle_name, ''))), lower(btrim(last_name))
+				HAVING count(*) > 1
+			UNION ALL SELECT 'actors', array_agg(id ORDER BY id),
+				format('%s appears %s times', concat_ws(' ', min(first_name), min(NULLIF(middle_name, '')), min(last_name)), count(*))
+				FROM actors GROUP BY lower(btrim(first_name)), lower(btrim(COALESCE(middle_name, ''))), lower(btrim(last_name))
+				HAVING count(*) > 1`,
+	},
+	{
+		name:        "untrimmed_names",
+		description: "Names and titles have no leading or trailing whitespace",
+		query: `SELECT 'directors', ARRAY[id], 'name has surrounding whitespace' FROM directors
+				WHERE first_name <> btrim(first_name) OR middle_name <> btrim(middle_name) OR last_name <> btrim(last_name)
+			UNION ALL SELECT 'actors', ARRAY[id], 'name has surrounding whitespace' FROM actors
+				WHERE first_name <> btrim(first_name) OR middle_name <> btrim(middle_name) OR last_name <> btrim(last_name)
+			UNION ALL SELECT 'films', ARRAY[id], 'title has surrounding whitespace' FROM films
+				WHERE title <> btrim(title)
+			UNION ALL SELECT 'characters', ARRAY[id], 'name has surrounding whitespace' FROM characters
+				WHERE name <> btrim(name)`,
+		fix: []string{
+			`UPDATE directors SET first_name = btrim(first_name), middle_name = btrim(middle_name), last_name = btrim(last_name)
+				WHERE first_name <> btrim(first_name) OR middle_name <> btrim(middle_name) OR last_name <> btrim(last_name)`,
+			`UPDATE actors SET first_name = btrim(first_name), middle_name = btrim(middle_name), last_name = btrim(last_name)
+				WHERE first_name <> btrim(first_name) OR middle_name <> btrim(middle_name) OR last_name <> btrim(last_name)`,
+			`UPDATE films SET title = btrim(title) WHERE title <> btrim(title)`,
+			`UPDATE characters SET name = btrim(name) WHERE name <> btrim(name)`,
+		},
+	},
+	{
+		name:        "null_columns",
+		description: "Columns the API reads as plain values are not NULL",
+		query: `SELECT 'directors', ARRAY[id], 'middle name is NULL' FROM directors WHERE middle_name IS NULL
+			UNION ALL SELECT 'actors', ARRAY[id], 'middle name is NULL' FROM actors WHERE middle_name IS NULL
+			UNION ALL SELECT 'characters', ARRAY[id], 'diesInTheEnd is NULL' FROM characters WHERE dies_in_the_end IS NULL`,
+		fix: []string{
+			`UPDATE directors SET middle_name = '' WHERE middle_name IS NULL`,
+			`UPDATE actors SET middle_name = '' WHERE middle_name IS NULL`,
+			`UPDATE characters SET dies_in_the_end = false WHERE dies_in_the_end IS NULL`,
+		},
+	},
+}
+
+// filmYearQuery checks Film.Year against the bounds of its validate tag,
+// so the rule follows the model.
+func filmYearQuery() string {
+	field, _ := reflect.TypeOf(Film{}).FieldByName("Year")
+	low, high := 0, 0
+	for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+		name, value, _ := strings.Cut(rule, "=")
+		switch name {
+		case "min":
+			low, _ = strconv.Atoi(value)
+		case "max":
+			high, _ = strconv.Atoi(value)
+		}
+	}
+	return fmt.Sprintf(`SELECT 'films', ARRAY[id], format('year %%s is outside %d-%d', year) FROM films
+		WHERE year NOT BETWEEN %d AND %d`, low, high, low, high)
+}
+
+// IntegrityRules lists the names of the rules CheckIntegrity runs.
+func IntegrityRules() []string {
+	names := make([]string, len(integrityRules))
+	for i, rule := range integrityRules {
+		names[i] = rule.name
+	}
+	return names
+}
+
+type UnknownRuleError struct {
+	Name string
+}
+
+func (e *UnknownRuleError) Error() string {
+	return fmt.Sprintf("unknown rule %q, want one of %s", e.Name, strings.Join(IntegrityRules(), ", "))
+}
+
+// CheckIntegrity runs the named rules, or all of them, over one consistent
+// snapshot. With fix set, rules that can be repaired safely are repaired
+// first and the findings left afterwards are reported.
+func CheckIntegrity(ctx context.Context, only []string, fix bool) (*IntegrityReport, error) {
+	for _, name := range only {
+		if !slices.Contains(IntegrityRules(), name) {
+			return nil, &UnknownRuleError{Name: name}
+		}
+	}
+	var rules []integrityRule
+	for _, rule := range integrityRules {
+		if len(only) == 0 || slices.Contains(only, rule.name) {
+			rules = append(rules, rule)
+		}
+	}
+
+	fixed := make(map[string]int)
+	if fix {
+		err := fixIntegrity(ctx, rules, fixed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	report := &IntegrityReport{}
+	err := Snapshot(ctx, func(e *Exporter) error {
+		for _, rule := range rules {
+			result := IntegrityResult{
+				Rule:        rule.name,
+				Description: rule.description,
+				Fixable:     len(rule.fix) > 0,
+				Fixed:       fixed[rule.name],
+			}
+			if rule.skip != "" {
+				result.Status, result.Reason = "skipped", rule.skip
+				report.Results = append(report.Results, result)
+				continue
+			}
+			findings, err := integrityFindings(ctx, e.tx, rule.query)
+			if err != nil {
+				return fmt.Errorf("rule %s: %w", rule.name, err)
+			}
+			if len(findings) > maxFindings {
+				findings, result.Truncated = findings[:maxFindings], true
+			}
+			result.Findings = findings
+			switch {
+			case len(findings) > 0:
+				result.Status = "failed"
+				report.Failed++
+			case result.Fixed > 0:
+				result.Status = "fixed"
+			default:
+				result.Status = "passed"
+			}
+			report.Results = append(report.Results, result)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func fixIntegrity(ctx context.Context, rules []integrityRule, fixed map[string]int) error {
+	tx, err := dbpool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, rule := range rules {
+		for _, statement := range rule.fix {
+			tag, err := tx.Exec(ctx, statement)
+			if err != nil {
+				return fmt.Errorf("fixing %s: %w", rule.name, err)
+			}
+			fixed[rule.name] += int(tag.RowsAffected())
+		}
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+	purgeCaches()
+	return nil
+}
+
+func integrityFindings(ctx context.Context, tx pgx.Tx, query string) ([]IntegrityFinding, error) {
+	rows, err := tx.Query(ctx, `SELECT * FROM (`+query+`) findings ORDER BY 1, 2 LIMIT `+strconv.Itoa(maxFindings+1))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var findings []IntegrityFinding
+	for rows.Next() {
+		var f IntegrityFinding
+		err = rows.Scan(&f.Table, &f.IDs, &f.Detail)
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, f)
+	}
+	return findings, rows.Err()
+}
+
+// WriteTable writes the report as a plain text table, one line per
+// finding.
+func (r *IntegrityReport) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RULE\tSTATUS\tTABLE\tIDS\tDETAIL")
+	for _, result := range r.Results {
+		detail := result.Description
+		switch {
+		case result.Reason != "":
+			detail += " (" + result.Reason + ")"
+		case result.Fixed > 0:
+			detail += fmt.Sprintf(" (fixed %d rows)", result.Fixed)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t\t\t%s\n", result.Rule, result.Status, detail)
+		for _, f := range result.Findings {
+			ids := make([]string, len(f.IDs))
+			for i, id := range f.IDs {
+				ids[i] = strconv.Itoa(id)
+			}
+			fmt.Fprintf(tw, "\t\t%s\t%s\t%s\n", f.Table, strings.Join(ids, ","), f.Detail)
+		}
+		if result.Truncated {
+			fmt.Fprintf(tw, "\t\t\t\tonly the first %d findings are shown\n", maxFindings)
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,10 @@
+CREATE UNLOGGED TABLE rate_limits(
+  key VARCHAR PRIMARY KEY,
+  tokens DOUBLE PRECISION NOT NULL,
+  allowed BOOLEAN NOT NULL,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT(now())
+);
+
+---- create above / drop below ----
+
+DROP TABLE rate_limits;
@@ -0,0 +1,35 @@
+ALTER TABLE directors ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT(now());
+ALTER TABLE actors ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT(now());
+ALTER TABLE films ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT(now());
+ALTER TABLE characters ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT(now());
+
+CREATE FUNCTION set_updated_at() RETURNS trigger AS $$
+BEGIN
+  NEW.updated_at = now();
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER directors_updated_at BEFORE UPDATE ON directors
+  FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+CREATE TRIGGER actors_updated_at BEFORE UPDATE ON actors
+  FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+CREATE TRIGGER films_updated_at BEFORE UPDATE ON films
+  FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+CREATE TRIGGER characters_updated_at BEFORE UPDATE ON characters
+  FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+CREATE INDEX characters_featured_in_updated_at ON characters(featured_in, updated_at);
+
+---- create above / drop below ----
+
+DROP INDEX characters_featured_in_updated_at;
+DROP TRIGGER characters_updated_at ON characters;
+DROP TRIGGER films_updated_at ON films;
+DROP TRIGGER actors_updated_at ON actors;
+DROP TRIGGER directors_updated_at ON directors;
+DROP FUNCTION set_updated_at();
+ALTER TABLE characters DROP COLUMN updated_at;
+ALTER TABLE films DROP COLUMN updated_at;
+ALTER TABLE actors DROP COLUMN updated_at;
+ALTER TABLE directors DROP COLUMN updated_at;
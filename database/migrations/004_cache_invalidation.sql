@@ -0,0 +1,40 @@
+CREATE FUNCTION notify_cache_invalidation() RETURNS trigger AS $$
+BEGIN
+  IF TG_LEVEL = 'STATEMENT' THEN
+    PERFORM pg_notify('cache_invalidation', TG_TABLE_NAME || ':*');
+  ELSE
+    PERFORM pg_notify('cache_invalidation', TG_TABLE_NAME || ':' || OLD.id);
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER directors_cache_invalidation AFTER UPDATE OR DELETE ON directors
+  FOR EACH ROW EXECUTE FUNCTION notify_cache_invalidation();
+CREATE TRIGGER actors_cache_invalidation AFTER UPDATE OR DELETE ON actors
+  FOR EACH ROW EXECUTE FUNCTION notify_cache_invalidation();
+CREATE TRIGGER films_cache_invalidation AFTER UPDATE OR DELETE ON films
+  FOR EACH ROW EXECUTE FUNCTION notify_cache_invalidation();
+CREATE TRIGGER characters_cache_invalidation AFTER UPDATE OR DELETE ON characters
+  FOR EACH ROW EXECUTE FUNCTION notify_cache_invalidation();
+
+CREATE TRIGGER directors_cache_truncate AFTER TRUNCATE ON directors
+  FOR EACH STATEMENT EXECUTE FUNCTION notify_cache_invalidation();
+CREATE TRIGGER actors_cache_truncate AFTER TRUNCATE ON actors
+  FOR EACH STATEMENT EXECUTE FUNCTION notify_cache_invalidation();
+CREATE TRIGGER films_cache_truncate AFTER TRUNCATE ON films
+  FOR EACH STATEMENT EXECUTE FUNCTION notify_cache_invalidation();
+CREATE TRIGGER characters_cache_truncate AFTER TRUNCATE ON characters
+  FOR EACH STATEMENT EXECUTE FUNCTION notify_cache_invalidation();
+
+---- create above / drop below ----
+
+DROP TRIGGER characters_cache_truncate ON characters;
+DROP TRIGGER films_cache_truncate ON films;
+DROP TRIGGER actors_cache_truncate ON actors;
+DROP TRIGGER directors_cache_truncate ON directors;
+DROP TRIGGER characters_cache_invalidation ON characters;
+DROP TRIGGER films_cache_invalidation ON films;
+DROP TRIGGER actors_cache_invalidation ON actors;
+DROP TRIGGER directors_cache_invalidation ON directors;
+DROP FUNCTION notify_cache_invalidation();
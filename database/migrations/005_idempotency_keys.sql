@@ -0,0 +1,14 @@
+CREATE TABLE idempotency_keys(
+  key VARCHAR PRIMARY KEY,
+  fingerprint BYTEA NOT NULL,
+  status INT NOT NULL,
+  header JSONB NOT NULL,
+  body BYTEA NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT(now())
+);
+
+CREATE INDEX idempotency_keys_created_at ON idempotency_keys(created_at);
+
+---- create above / drop below ----
+
+DROP TABLE idempotency_keys;
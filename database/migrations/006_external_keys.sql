@@ -0,0 +1,11 @@
+ALTER TABLE directors ADD COLUMN external_key VARCHAR UNIQUE;
+ALTER TABLE actors ADD COLUMN external_key VARCHAR UNIQUE;
+ALTER TABLE films ADD COLUMN external_key VARCHAR UNIQUE;
+ALTER TABLE characters ADD COLUMN external_key VARCHAR UNIQUE;
+
+---- create above / drop below ----
+
+ALTER TABLE characters DROP COLUMN external_key;
+ALTER TABLE films DROP COLUMN external_key;
+ALTER TABLE actors DROP COLUMN external_key;
+ALTER TABLE directors DROP COLUMN external_key;
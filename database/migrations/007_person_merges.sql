@@ -0,0 +1,18 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE TABLE merge_audit(
+  id INT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+  entity VARCHAR NOT NULL,
+  survivor_id INT NOT NULL,
+  merged_id INT NOT NULL,
+  merged JSONB NOT NULL,
+  repointed INT[] NOT NULL,
+  merged_by VARCHAR NOT NULL,
+  merged_at TIMESTAMPTZ NOT NULL DEFAULT(now())
+);
+
+CREATE INDEX merge_audit_survivor ON merge_audit(entity, survivor_id);
+
+---- create above / drop below ----
+
+DROP TABLE merge_audit;
@@ -0,0 +1,13 @@
+ALTER TABLE idempotency_keys
+  ALTER COLUMN status DROP NOT NULL,
+  ALTER COLUMN header DROP NOT NULL,
+  ALTER COLUMN body DROP NOT NULL;
+
+---- create above / drop below ----
+
+DELETE FROM idempotency_keys WHERE status IS NULL;
+
+ALTER TABLE idempotency_keys
+  ALTER COLUMN status SET NOT NULL,
+  ALTER COLUMN header SET NOT NULL,
+  ALTER COLUMN body SET NOT NULL;
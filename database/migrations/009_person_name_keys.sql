@@ -0,0 +1,50 @@
+CREATE EXTENSION IF NOT EXISTS unaccent;
+
+-- person_name_key reduces a name to lower case Latin words without
+-- diacritics, so "José Ramírez" becomes "jose ramirez" and Cyrillic names
+-- are transliterated. Punctuation such as hyphens and the periods of
+-- initials separates words. unaccent is called with its dictionary named
+-- and the search_path pinned, which keeps the function immutable enough to
+-- generate a column from.
+CREATE FUNCTION person_name_key(first_name TEXT, last_name TEXT) RETURNS TEXT
+LANGUAGE plpgsql IMMUTABLE PARALLEL SAFE
+SET search_path FROM CURRENT
+AS $$
+DECLARE
+  name TEXT := lower(concat_ws(' ', first_name, last_name));
+  -- Letters that do not decompose into a Latin base letter and marks,
+  -- each followed by its spelling.
+  letters TEXT[] := ARRAY[
+    'æ', 'ae', 'œ', 'oe', 'ø', 'o', 'ł', 'l', 'đ', 'd', 'ð', 'd', 'þ', 'th', 'ı', 'i',
+    'а', 'a', 'б', 'b', 'в', 'v', 'г', 'g', 'д', 'd', 'е', 'e', 'ё', 'e', 'ж', 'zh',
+    'з', 'z', 'и', 'i', 'й', 'y', 'к', 'k', 'л', 'l', 'м', 'm', 'н', 'n', 'о', 'o',
+    'п', 'p', 'р', 'r', 'с', 's', 'т', 't', 'у', 'u', 'ф', 'f', 'х', 'kh', 'ц', 'ts',
+    'ч', 'ch', 'ш', 'sh', 'щ', 'shch', 'ъ', '', 'ы', 'y', 'ь', '', 'э', 'e', 'ю', 'yu',
+    'я', 'ya'
+  ];
+BEGIN
+  FOR i IN 1 .. array_length(letters, 1) BY 2 LOOP
+    name := replace(name, letters[i], letters[i + 1]);
+  END LOOP;
+  name := unaccent('unaccent', name);
+  RETURN btrim(regexp_replace(name, '[^[:alnum:]]+', ' ', 'g'));
+END;
+$$;
+
+-- name_key compares first and last names only, as a missing middle name is
+-- the most common difference between duplicates.
+ALTER TABLE directors ADD COLUMN name_key TEXT
+  GENERATED ALWAYS AS (person_name_key(first_name, last_name)) STORED;
+ALTER TABLE actors ADD COLUMN name_key TEXT
+  GENERATED ALWAYS AS (person_name_key(first_name, last_name)) STORED;
+
+CREATE INDEX directors_name_key ON directors USING gin (name_key gin_trgm_ops);
+CREATE INDEX actors_name_key ON actors USING gin (name_key gin_trgm_ops);
+
+---- create above / drop below ----
+
+DROP INDEX actors_name_key;
+DROP INDEX directors_name_key;
+ALTER TABLE actors DROP COLUMN name_key;
+ALTER TABLE directors DROP COLUMN name_key;
+DROP FUNCTION person_name_key(TEXT, TEXT);
@@ -1,31 +1,68 @@
 package database
 
+import "time"
+
 type Director struct {
-	ID         int    `json:"id"`
-	FirstName  string `json:"firstName" validate:"required"`
-	MiddleName string `json:"middleName"`
-	LastName   string `json:"lastName" validate:"required"`
+	ID         int       `json:"id"`
+	FirstName  string    `json:"firstName" validate:"required"`
+	MiddleName string    `json:"middleName"`
+	LastName   string    `json:"lastName" validate:"required"`
+	UpdatedAt  time.Time `json:"-"`
 }
 
 type Actor struct {
-	ID         int    `json:"id"`
-	FirstName  string `json:"firstName" validate:"required"`
-	MiddleName string `json:"middleName"`
-	LastName   string `json:"lastName" validate:"required"`
+	ID         int       `json:"id"`
+	FirstName  string    `json:"firstName" validate:"required"`
+	MiddleName string    `json:"middleName"`
+	LastName   string    `json:"lastName" validate:"required"`
+	UpdatedAt  time.Time `json:"-"`
 }
 
 type Film struct {
-	ID         int    `json:"id"`
-	Title      string `json:"title" validate:"required"`
-	DirectedBy int    `json:"directedBy" validate:"required"`
-	Logline    string `json:"logline" validate:"required"`
-	Year       int    `json:"year" validate:"required,min=1900,max=2040"`
+	ID         int       `json:"id"`
+	Title      string    `json:"title" validate:"required"`
+	DirectedBy int       `json:"directedBy" validate:"required"`
+	Logline    string    `json:"logline" validate:"required"`
+	Year       int       `json:"year" validate:"required,min=1900,max=2040"`
+	UpdatedAt  time.Time `json:"-"`
 }
 
 type Character struct {
-	ID           int    `json:"id"`
+	ID           int       `json:"id"`
+	Name         string    `json:"name" validate:"required"`
+	PortrayedBy  int       `json:"portrayedBy" validate:"required"`
+	FeaturedIn   int       `json:"featuredIn" validate:"required"`
+	DiesInTheEnd bool      `json:"diesInTheEnd" validate:"boolean"`
+	UpdatedAt    time.Time `json:"-"`
+}
+
+// NewFilm is a film to create together with its director and characters.
+// Director replaces directedBy; each character names an existing actor
+// with portrayedBy or a new one with actor.
+type NewFilm struct {
+	Title      string         `json:"title" validate:"required"`
+	DirectedBy int            `json:"directedBy" validate:"required_without=Director,excluded_with=Director"`
+	Director   *Director      `json:"director,omitempty"`
+	Logline    string         `json:"logline" validate:"required"`
+	Year       int            `json:"year" validate:"required,min=1900,max=2040"`
+	Characters []NewCharacter `json:"characters,omitempty" validate:"dive"`
+}
+
+type NewCharacter struct {
 	Name         string `json:"name" validate:"required"`
-	PortrayedBy  int    `json:"portrayedBy" validate:"required"`
-	FeaturedIn   int    `json:"featuredIn" validate:"required"`
+	PortrayedBy  int    `json:"portrayedBy" validate:"required_without=Actor,excluded_with=Actor"`
+	Actor        *Actor `json:"actor,omitempty"`
 	DiesInTheEnd bool   `json:"diesInTheEnd" validate:"boolean"`
 }
+
+// FilmDocument is a film expanded with its director and characters.
+type FilmDocument struct {
+	Film
+	Director   Director            `json:"director"`
+	Characters []CharacterDocument `json:"characters"`
+}
+
+type CharacterDocument struct {
+	Character
+	Actor Actor `json:"actor"`
+}
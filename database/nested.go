@@ -0,0 +1,85 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// CreateFilmDocument creates a film with its inline director, actors and
+// characters in one transaction, so a failure leaves nothing behind. It
+// returns a *ReferenceError if the film names a director or actor that
+// does not exist.
+func CreateFilmDocument(ctx context.Context, newFilm NewFilm) (*FilmDocument, error) {
+	tx, err := dbpool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var doc FilmDocument
+	director, err := directorFor(ctx, tx, newFilm)
+	if err != nil {
+		return nil, err
+	}
+	doc.Director = *director
+
+	film, err := insertFilm(ctx, tx, Film{
+		Title:      newFilm.Title,
+		DirectedBy: director.ID,
+		Logline:    newFilm.Logline,
+		Year:       newFilm.Year,
+	})
+	if err != nil {
+		return nil, err
+	}
+	doc.Film = *film
+
+	doc.Characters = []CharacterDocument{}
+	for _, newCharacter := range newFilm.Characters {
+		actor, err := actorFor(ctx, tx, newCharacter)
+		if err != nil {
+			return nil, err
+		}
+		character, err := insertCharacter(ctx, tx, Character{
+			Name:         newCharacter.Name,
+			PortrayedBy:  actor.ID,
+			FeaturedIn:   film.ID,
+			DiesInTheEnd: newCharacter.DiesInTheEnd,
+		})
+		if err != nil {
+			return nil, err
+		}
+		doc.Characters = append(doc.Characters, CharacterDocument{Character: *character, Actor: *actor})
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+func directorFor(ctx context.Context, q querier, newFilm NewFilm) (*Director, error) {
+	if newFilm.Director != nil {
+		return insertDirector(ctx, q, *newFilm.Director)
+	}
+	director, err := selectDirector(ctx, q, strconv.Itoa(newFilm.DirectedBy))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, &ReferenceError{Entity: "director", ID: newFilm.DirectedBy}
+	}
+	return director, err
+}
+
+func actorFor(ctx context.Context, q querier, newCharacter NewCharacter) (*Actor, error) {
+	if newCharacter.Actor != nil {
+		return insertActor(ctx, q, *newCharacter.Actor)
+	}
+	actor, err := selectActor(ctx, q, strconv.Itoa(newCharacter.PortrayedBy))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, &ReferenceError{Entity: "actor", ID: newCharacter.PortrayedBy}
+	}
+	return actor, err
+}
@@ -2,475 +2,512 @@ package database
 
 import (
 	"context"
+	"strconv"
 
 	"github.com/jackc/pgx/v5"
 )
 
-func CreateDirector(director Director) (*Director, error) {
-	conn, err := dbpool.Acquire(context.Background())
+func CreateDirector(ctx context.Context, director Director) (*Director, error) {
+	conn, err := dbpool.Acquire(ctx)
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Release()
 
-	row := conn.QueryRow(context.Background(),
+	return insertDirector(ctx, conn, director)
+}
+
+func insertDirector(ctx context.Context, q querier, director Director) (*Director, error) {
+	row := q.QueryRow(ctx,
 		`INSERT INTO directors
 		(first_name, middle_name, last_name) 
 		VALUES
 		($1, $2, $3)
 		RETURNING
-		(id, first_name, middle_name, last_name)`,
+		(id, first_name, middle_name, last_name, updated_at)`,
 		&director.FirstName, &director.MiddleName, &director.LastName,
 	)
 
-	err = row.Scan(&director)
+	err := row.Scan(&director)
 	if err != nil {
 		return nil, err
 	}
 	return &director, nil
 }
 
-func FindFirstDirector(id string) (*Director, error) {
-	var director Director
-	conn, err := dbpool.Acquire(context.Background())
+func findFirstDirector(ctx context.Context, id string) (*Director, error) {
+	conn, err := dbpool.Acquire(ctx)
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Release()
 
-	err = conn.QueryRow(context.Background(),
-		`SELECT * FROM directors WHERE id = $1 LIMIT 1`,
+	return selectDirector(ctx, conn, id)
+}
+
+func selectDirector(ctx context.Context, q querier, id string) (*Director, error) {
+	var director Director
+	err := q.QueryRow(ctx,
+		`SELECT id, first_name, middle_name, last_name, updated_at FROM directors WHERE id = $1 LIMIT 1`,
 		id,
-	).Scan(&director.ID, &director.FirstName, &director.MiddleName, &director.LastName)
+	).Scan(&director.ID, &director.FirstName, &director.MiddleName, &director.LastName, &director.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return &director, nil
 }
 
-func FindDirectors() (*[]Director, error) {
-	var directors []Director
-
-	conn, err := dbpool.Acquire(context.Background())
+// EachDirector calls fn with every director in turn as the rows arrive,
+// so only one is held in memory. fn must not keep the pointer.
+func EachDirector(ctx context.Context, fn func(*Director) error) error {
+	conn, err := dbpool.Acquire(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer conn.Release()
 
-	rows, err := conn.Query(context.Background(), `SELECT * FROM directors`)
+	rows, err := conn.Query(ctx, `SELECT id, first_name, middle_name, last_name, updated_at FROM directors`)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
+	var director Director
 	for rows.Next() {
-		var director Director
-		err = rows.Scan(&director.ID, &director.FirstName, &director.MiddleName, &director.LastName)
+		err = rows.Scan(&director.ID, &director.FirstName, &director.MiddleName, &director.LastName, &director.UpdatedAt)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		err = fn(&director)
+		if err != nil {
+			return err
 		}
-		directors = append(directors, director)
-	}
-	err = rows.Err()
-	if err != nil {
-		return nil, err
 	}
-	return &directors, nil
+	return rows.Err()
 }
 
-func UpdateDirector(director Director) (*Director, error) {
-	conn, err := dbpool.Acquire(context.Background())
+func UpdateDirector(ctx context.Context, director Director) (*Director, error) {
+	conn, err := dbpool.Acquire(ctx)
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Release()
 
-	ct, err := conn.Exec(context.Background(),
+	ct, err := conn.Exec(ctx,
 		`UPDATE directors SET 
 		first_name=$1, middle_name=$2, last_name=$3
 		WHERE id = $4`,
 		director.FirstName, director.MiddleName, director.LastName, director.ID,
 	)
-	if ct.RowsAffected() == 0 {
-		return nil, pgx.ErrNoRows
-	}
 	if err != nil {
 		return nil, err
 	}
+	if ct.RowsAffected() == 0 {
+		return nil, pgx.ErrNoRows
+	}
+	invalidate("directors", strconv.Itoa(director.ID))
 	return &director, nil
 }
 
-func DeleteDirector(id string) error {
-	conn, err := dbpool.Acquire(context.Background())
+func DeleteDirector(ctx context.Context, id string) error {
+	conn, err := dbpool.Acquire(ctx)
 	if err != nil {
 		return err
 	}
 	defer conn.Release()
 
-	ct, err := conn.Exec(context.Background(), `DELETE FROM directors WHERE id=$1`, id)
-	if ct.RowsAffected() == 0 {
-		return pgx.ErrNoRows
-	}
+	ct, err := conn.Exec(ctx, `DELETE FROM directors WHERE id=$1`, id)
 	if err != nil {
 		return err
 	}
+	if ct.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	invalidate("directors", id)
 	return nil
 }
 
-func CreateActor(actor Actor) (*Actor, error) {
-	conn, err := dbpool.Acquire(context.Background())
+func CreateActor(ctx context.Context, actor Actor) (*Actor, error) {
+	conn, err := dbpool.Acquire(ctx)
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Release()
 
-	row := conn.QueryRow(context.Background(),
+	return insertActor(ctx, conn, actor)
+}
+
+func insertActor(ctx context.Context, q querier, actor Actor) (*Actor, error) {
+	row := q.QueryRow(ctx,
 		`INSERT INTO actors
 		(first_name, middle_name, last_name) 
 		VALUES
 		($1, $2, $3)
 		RETURNING
-		(id, first_name, middle_name, last_name)`,
+		(id, first_name, middle_name, last_name, updated_at)`,
 		&actor.FirstName, &actor.MiddleName, &actor.LastName,
 	)
 
-	err = row.Scan(&actor)
+	err := row.Scan(&actor)
 	if err != nil {
 		return nil, err
 	}
 	return &actor, nil
 }
 
-func FindFirstActor(id string) (*Actor, error) {
-	var actor Actor
-	conn, err := dbpool.Acquire(context.Background())
+func findFirstActor(ctx context.Context, id string) (*Actor, error) {
+	conn, err := dbpool.Acquire(ctx)
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Release()
 
-	err = conn.QueryRow(context.Background(),
-		`SELECT * FROM actors WHERE id = $1 LIMIT 1`,
+	return selectActor(ctx, conn, id)
+}
+
+func selectActor(ctx context.Context, q querier, id string) (*Actor, error) {
+	var actor Actor
+	err := q.QueryRow(ctx,
+		`SELECT id, first_name, middle_name, last_name, updated_at FROM actors WHERE id = $1 LIMIT 1`,
 		id,
-	).Scan(&actor.ID, &actor.FirstName, &actor.MiddleName, &actor.LastName)
+	).Scan(&actor.ID, &actor.FirstName, &actor.MiddleName, &actor.LastName, &actor.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return &actor, nil
 }
 
-func FindActors() (*[]Actor, error) {
-	var actors []Actor
-
-	conn, err := dbpool.Acquire(context.Background())
+// EachActor calls fn with every actor in turn as the rows arrive,
+// so only one is held in memory. fn must not keep the pointer.
+func EachActor(ctx context.Context, fn func(*Actor) error) error {
+	conn, err := dbpool.Acquire(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer conn.Release()
 
-	rows, err := conn.Query(context.Background(), `SELECT * FROM actors`)
+	rows, err := conn.Query(ctx, `SELECT id, first_name, middle_name, last_name, updated_at FROM actors`)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
+	var actor Actor
 	for rows.Next() {
-		var actor Actor
-		err = rows.Scan(&actor.ID, &actor.FirstName, &actor.MiddleName, &actor.LastName)
+		err = rows.Scan(&actor.ID, &actor.FirstName, &actor.MiddleName, &actor.LastName, &actor.UpdatedAt)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		err = fn(&actor)
+		if err != nil {
+			return err
 		}
-		actors = append(actors, actor)
-	}
-	err = rows.Err()
-	if err != nil {
-		return nil, err
 	}
-	return &actors, nil
+	return rows.Err()
 }
 
-func UpdateActor(actor Actor) (*Actor, error) {
-	conn, err := dbpool.Acquire(context.Background())
+func UpdateActor(ctx context.Context, actor Actor) (*Actor, error) {
+	conn, err := dbpool.Acquire(ctx)
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Release()
 
-	ct, err := conn.Exec(context.Background(),
+	ct, err := conn.Exec(ctx,
 		`UPDATE actors SET 
 		first_name=$1, middle_name=$2, last_name=$3
 		WHERE id = $4`,
 		actor.FirstName, actor.MiddleName, actor.LastName, actor.ID,
 	)
-	if ct.RowsAffected() == 0 {
-		return nil, pgx.ErrNoRows
-	}
 	if err != nil {
 		return nil, err
 	}
+	if ct.RowsAffected() == 0 {
+		return nil, pgx.ErrNoRows
+	}
+	invalidate("actors", strconv.Itoa(actor.ID))
 	return &actor, nil
 }
 
-func DeleteActor(id string) error {
-	conn, err := dbpool.Acquire(context.Background())
+func DeleteActor(ctx context.Context, id string) error {
+	conn, err := dbpool.Acquire(ctx)
 	if err != nil {
 		return err
 	}
 	defer conn.Release()
 
-	ct, err := conn.Exec(context.Background(), `DELETE FROM actors WHERE id=$1`, id)
-	if ct.RowsAffected() == 0 {
-		return pgx.ErrNoRows
-	}
+	ct, err := conn.Exec(ctx, `DELETE FROM actors WHERE id=$1`, id)
 	if err != nil {
 		return err
 	}
+	if ct.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	invalidate("actors", id)
 	return nil
 }
 
-func CreateFilm(film Film) (*Film, error) {
-	conn, err := dbpool.Acquire(context.Background())
+func CreateFilm(ctx context.Context, film Film) (*Film, error) {
+	conn, err := dbpool.Acquire(ctx)
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Release()
 
-	row := conn.QueryRow(context.Background(),
+	return insertFilm(ctx, conn, film)
+}
+
+func insertFilm(ctx context.Context, q querier, film Film) (*Film, error) {
+	row := q.QueryRow(ctx,
 		`INSERT INTO films
 		(title, directed_by, logline, year) 
 		VALUES
 		($1, $2, $3, $4)
 		RETURNING
-		(id, title, directed_by, logline, year)`,
+		(id, title, directed_by, logline, year, updated_at)`,
 		&film.Title, &film.DirectedBy, &film.Logline, &film.Year,
 	)
 
-	err = row.Scan(&film)
+	err := row.Scan(&film)
 	if err != nil {
-		return nil, err
+		return nil, asReferenceError(err, filmReferences(film))
 	}
 	return &film, nil
 }
 
-func FindFirstFilm(id string) (*Film, error) {
-	var film Film
-	conn, err := dbpool.Acquire(context.Background())
+func findFirstFilm(ctx context.Context, id string) (*Film, error) {
+	conn, err := dbpool.Acquire(ctx)
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Release()
 
-	err = conn.QueryRow(context.Background(),
-		`SELECT * FROM films WHERE id = $1 LIMIT 1`,
+	return selectFilm(ctx, conn, id)
+}
+
+func selectFilm(ctx context.Context, q querier, id string) (*Film, error) {
+	var film Film
+	err := q.QueryRow(ctx,
+		`SELECT id, title, directed_by, logline, year, updated_at FROM films WHERE id = $1 LIMIT 1`,
 		id,
-	).Scan(&film.ID, &film.Title, &film.DirectedBy, &film.Logline, &film.Year)
+	).Scan(&film.ID, &film.Title, &film.DirectedBy, &film.Logline, &film.Year, &film.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return &film, nil
 }
 
-func FindFilms() (*[]Film, error) {
-	var films []Film
-
-	conn, err := dbpool.Acquire(context.Background())
+// EachFilm calls fn with every film in turn as the rows arrive,
+// so only one is held in memory. fn must not keep the pointer.
+func EachFilm(ctx context.Context, fn func(*Film) error) error {
+	conn, err := dbpool.Acquire(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer conn.Release()
 
-	rows, err := conn.Query(context.Background(), `SELECT * FROM films`)
+	rows, err := conn.Query(ctx, `SELECT id, title, directed_by, logline, year, updated_at FROM films`)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
+	var film Film
 	for rows.Next() {
-		var film Film
-		err = rows.Scan(&film.ID, &film.Title, &film.DirectedBy, &film.Logline, &film.Year)
+		err = rows.Scan(&film.ID, &film.Title, &film.DirectedBy, &film.Logline, &film.Year, &film.UpdatedAt)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		err = fn(&film)
+		if err != nil {
+			return err
 		}
-		films = append(films, film)
-	}
-	err = rows.Err()
-	if err != nil {
-		return nil, err
 	}
-	return &films, nil
+	return rows.Err()
 }
 
-func UpdateFilm(film Film) (*Film, error) {
-	conn, err := dbpool.Acquire(context.Background())
+func UpdateFilm(ctx context.Context, film Film) (*Film, error) {
+	conn, err := dbpool.Acquire(ctx)
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Release()
 
-	ct, err := conn.Exec(context.Background(),
+	ct, err := conn.Exec(ctx,
 		`UPDATE films SET 
 		title=$1, directed_by=$2, logline=$3, year=$4
 		WHERE id = $5`,
 		film.Title, film.DirectedBy, film.Logline, film.Year, film.ID,
 	)
+	if err != nil {
+		return nil, asReferenceError(err, filmReferences(film))
+	}
 	if ct.RowsAffected() == 0 {
 		return nil, pgx.ErrNoRows
 	}
-	if err != nil {
-		return nil, err
-	}
+	invalidate("films", strconv.Itoa(film.ID))
 	return &film, nil
 }
 
-func DeleteFilm(id string) error {
-	conn, err := dbpool.Acquire(context.Background())
+func DeleteFilm(ctx context.Context, id string) error {
+	conn, err := dbpool.Acquire(ctx)
 	if err != nil {
 		return err
 	}
 	defer conn.Release()
 
-	ct, err := conn.Exec(context.Background(), `DELETE FROM films WHERE id=$1`, id)
-	if ct.RowsAffected() == 0 {
-		return pgx.ErrNoRows
-	}
+	ct, err := conn.Exec(ctx, `DELETE FROM films WHERE id=$1`, id)
 	if err != nil {
 		return err
 	}
+	if ct.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	invalidate("films", id)
 	return nil
 }
 
-func CreateCharacter(character Character) (*Character, error) {
-	conn, err := dbpool.Acquire(context.Background())
+func CreateCharacter(ctx context.Context, character Character) (*Character, error) {
+	conn, err := dbpool.Acquire(ctx)
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Release()
 
-	row := conn.QueryRow(context.Background(),
+	return insertCharacter(ctx, conn, character)
+}
+
+func insertCharacter(ctx context.Context, q querier, character Character) (*Character, error) {
+	row := q.QueryRow(ctx,
 		`INSERT INTO characters
 		(name, portrayed_by, featured_in, dies_in_the_end) 
 		VALUES
 		($1, $2, $3, $4)
 		RETURNING
-		(id, name, portrayed_by, featured_in, dies_in_the_end)`,
+		(id, name, portrayed_by, featured_in, dies_in_the_end, updated_at)`,
 		&character.Name, &character.PortrayedBy, &character.FeaturedIn, &character.DiesInTheEnd,
 	)
 
-	err = row.Scan(&character)
+	err := row.Scan(&character)
 	if err != nil {
-		return nil, err
+		return nil, asReferenceError(err, characterReferences(character))
 	}
 	return &character, nil
 }
 
-func FindFirstCharacter(id string) (*Character, error) {
-	var character Character
-	conn, err := dbpool.Acquire(context.Background())
+func findFirstCharacter(ctx context.Context, id string) (*Character, error) {
+	conn, err := dbpool.Acquire(ctx)
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Release()
 
-	err = conn.QueryRow(context.Background(),
-		`SELECT * FROM characters WHERE id = $1 LIMIT 1`,
+	return selectCharacter(ctx, conn, id)
+}
+
+func selectCharacter(ctx context.Context, q querier, id string) (*Character, error) {
+	var character Character
+	err := q.QueryRow(ctx,
+		`SELECT id, name, portrayed_by, featured_in, dies_in_the_end, updated_at FROM characters WHERE id = $1 LIMIT 1`,
 		id,
-	).Scan(&character.ID, &character.Name, &character.PortrayedBy, &character.FeaturedIn, &character.DiesInTheEnd)
+	).Scan(&character.ID, &character.Name, &character.PortrayedBy, &character.FeaturedIn, &character.DiesInTheEnd, &character.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return &character, nil
 }
 
-func FindCharacters() (*[]Character, error) {
-	var characters []Character
-
-	conn, err := dbpool.Acquire(context.Background())
+// EachCharacter calls fn with every character in turn as the rows arrive,
+// so only one is held in memory. fn must not keep the pointer.
+func EachCharacter(ctx context.Context, fn func(*Character) error) error {
+	conn, err := dbpool.Acquire(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer conn.Release()
 
-	rows, err := conn.Query(context.Background(), `SELECT * FROM characters`)
+	rows, err := conn.Query(ctx, `SELECT id, name, portrayed_by, featured_in, dies_in_the_end, updated_at FROM characters`)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
+	var character Character
 	for rows.Next() {
-		var character Character
-		err = rows.Scan(&character.ID, &character.Name, &character.PortrayedBy, &character.FeaturedIn, &character.DiesInTheEnd)
+		err = rows.Scan(&character.ID, &character.Name, &character.PortrayedBy, &character.FeaturedIn, &character.DiesInTheEnd, &character.UpdatedAt)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		err = fn(&character)
+		if err != nil {
+			return err
 		}
-		characters = append(characters, character)
-	}
-	err = rows.Err()
-	if err != nil {
-		return nil, err
 	}
-	return &characters, nil
+	return rows.Err()
 }
 
-func FindCharactersByFilm(filmId string) (*[]Character, error) {
-	var characters []Character
-
-	conn, err := dbpool.Acquire(context.Background())
+// EachCharacterOfFilm calls fn with every character featured in the film
+// in turn as the rows arrive, so only one is held in memory. fn must not
+// keep the pointer.
+func EachCharacterOfFilm(ctx context.Context, filmId string, fn func(*Character) error) error {
+	conn, err := dbpool.Acquire(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer conn.Release()
 
-	rows, err := conn.Query(context.Background(), `SELECT * FROM characters WHERE featured_in=$1`, filmId)
+	rows, err := conn.Query(ctx, `SELECT id, name, portrayed_by, featured_in, dies_in_the_end, updated_at FROM characters WHERE featured_in=$1`, filmId)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
+	var character Character
 	for rows.Next() {
-		var character Character
-		err = rows.Scan(&character.ID, &character.Name, &character.PortrayedBy, &character.FeaturedIn, &character.DiesInTheEnd)
+		err = rows.Scan(&character.ID, &character.Name, &character.PortrayedBy, &character.FeaturedIn, &character.DiesInTheEnd, &character.UpdatedAt)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		err = fn(&character)
+		if err != nil {
+			return err
 		}
-		characters = append(characters, character)
-	}
-	err = rows.Err()
-	if err != nil {
-		return nil, err
 	}
-	return &characters, nil
+	return rows.Err()
 }
 
-func UpdateCharacter(character Character) (*Character, error) {
-	conn, err := dbpool.Acquire(context.Background())
+func UpdateCharacter(ctx context.Context, character Character) (*Character, error) {
+	conn, err := dbpool.Acquire(ctx)
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Release()
 
-	ct, err := conn.Exec(context.Background(),
+	ct, err := conn.Exec(ctx,
 		`UPDATE characters SET 
 		name=$1, portrayed_by=$2, featured_in=$3, dies_in_the_end=$4
 		WHERE id = $5`,
 		character.Name, character.PortrayedBy, character.FeaturedIn, character.DiesInTheEnd, character.ID,
 	)
+	if err != nil {
+		return nil, asReferenceError(err, characterReferences(character))
+	}
 	if ct.RowsAffected() == 0 {
 		return nil, pgx.ErrNoRows
 	}
-	if err != nil {
-		return nil, err
-	}
+	invalidate("characters", strconv.Itoa(character.ID))
 	return &character, nil
 }
 
-func DeleteCharacter(id string) error {
-	conn, err := dbpool.Acquire(context.Background())
+func DeleteCharacter(ctx context.Context, id string) error {
+	conn, err := dbpool.Acquire(ctx)
 	if err != nil {
 		return err
 	}
 	defer conn.Release()
 
-	ct, err := conn.Exec(context.Background(), `DELETE FROM characters WHERE id=$1`, id)
-	if ct.RowsAffected() == 0 {
-		return pgx.ErrNoRows
-	}
+	ct, err := conn.Exec(ctx, `DELETE FROM characters WHERE id=$1`, id)
 	if err != nil {
 		return err
 	}
+	if ct.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	invalidate("characters", id)
 	return nil
 }
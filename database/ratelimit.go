@@ -0,0 +1,45 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// TakeRateLimitToken refills the token bucket stored under key and takes one
+// token from it if one is available, in a single statement so that replicas
+// sharing the database share the bucket. It returns the tokens left and
+// whether the request was allowed.
+func TakeRateLimitToken(ctx context.Context, key string, rate float64, burst int) (float64, bool, error) {
+	var tokens float64
+	var allowed bool
+	err := dbpool.QueryRow(ctx,
+		`INSERT INTO rate_limits AS rl
+		(key, tokens, allowed, updated_at)
+		VALUES
+		($1, $3::float8 - 1, true, now())
+		ON CONFLICT (key) DO UPDATE SET
+		tokens = CASE
+			WHEN LEAST($3::float8, rl.tokens + EXTRACT(EPOCH FROM now() - rl.updated_at)::float8 * $2::float8) >= 1
+			THEN LEAST($3::float8, rl.tokens + EXTRACT(EPOCH FROM now() - rl.updated_at)::float8 * $2::float8) - 1
+			ELSE LEAST($3::float8, rl.tokens + EXTRACT(EPOCH FROM now() - rl.updated_at)::float8 * $2::float8)
+		END,
+		allowed = LEAST($3::float8, rl.tokens + EXTRACT(EPOCH FROM now() - rl.updated_at)::float8 * $2::float8) >= 1,
+		updated_at = now()
+		RETURNING tokens, allowed`,
+		key, rate, burst,
+	).Scan(&tokens, &allowed)
+	if err != nil {
+		return 0, false, err
+	}
+	return tokens, allowed, nil
+}
+
+// PruneRateLimits removes buckets that have not been touched for a while.
+// Such buckets are full again, so dropping them changes nothing.
+func PruneRateLimits(ctx context.Context, idle time.Duration) error {
+	_, err := dbpool.Exec(ctx,
+		`DELETE FROM rate_limits WHERE updated_at < now() - make_interval(secs => $1)`,
+		idle.Seconds(),
+	)
+	return err
+}
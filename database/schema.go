@@ -0,0 +1,45 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"io/fs"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// ExpectedSchemaVersion is the schema version this binary was built against:
+// the number of the last migration in database/migrations.
+func ExpectedSchemaVersion() int {
+	files, _ := fs.Glob(migrations, "migrations/*.sql")
+	return len(files)
+}
+
+// migrationsTable is the table tern records the schema version in. It may
+// be qualified by a schema, e.g. "public.schema_version".
+var migrationsTable = "schema_migrations"
+
+// SchemaVersion reads the version tern recorded after migrating. Migrations
+// must be run with `tern migrate --version-table` naming db.migrations_table,
+// which defaults to schema_migrations rather than tern's schema_version.
+func SchemaVersion(ctx context.Context) (int, error) {
+	return schemaVersion(ctx, dbpool)
+}
+
+func schemaVersion(ctx context.Context, q querier) (int, error) {
+	var version int
+	table := pgx.Identifier(strings.Split(migrationsTable, ".")).Sanitize()
+	err := q.QueryRow(ctx, `SELECT version FROM `+table).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+func Ping(ctx context.Context) error {
+	return dbpool.Ping(ctx)
+}
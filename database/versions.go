@@ -0,0 +1,46 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// ListVersion identifies the state of a list of rows: any insert, update or
+// delete changes either the count or the newest updated_at.
+type ListVersion struct {
+	Count        int
+	LastModified time.Time
+}
+
+func DirectorsVersion(ctx context.Context) (ListVersion, error) {
+	return listVersion(ctx, `SELECT count(*), max(updated_at) FROM directors`)
+}
+
+func ActorsVersion(ctx context.Context) (ListVersion, error) {
+	return listVersion(ctx, `SELECT count(*), max(updated_at) FROM actors`)
+}
+
+func FilmsVersion(ctx context.Context) (ListVersion, error) {
+	return listVersion(ctx, `SELECT count(*), max(updated_at) FROM films`)
+}
+
+func CharactersVersion(ctx context.Context) (ListVersion, error) {
+	return listVersion(ctx, `SELECT count(*), max(updated_at) FROM characters`)
+}
+
+func CharactersByFilmVersion(ctx context.Context, filmId string) (ListVersion, error) {
+	return listVersion(ctx, `SELECT count(*), max(updated_at) FROM characters WHERE featured_in=$1`, filmId)
+}
+
+func listVersion(ctx context.Context, query string, args ...any) (ListVersion, error) {
+	var v ListVersion
+	var lastModified *time.Time
+	err := dbpool.QueryRow(ctx, query, args...).Scan(&v.Count, &lastModified)
+	if err != nil {
+		return ListVersion{}, err
+	}
+	if lastModified != nil {
+		v.LastModified = *lastModified
+	}
+	return v, nil
+}
@@ -0,0 +1,338 @@
+// Package importer bulk loads directors, actors, films and characters from
+// CSV or JSON Lines, for both the import endpoints and the import command.
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-test/database"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// maxErrors bounds the row errors collected before giving up on a file.
+const maxErrors = 1000
+
+// ExternalKey is the field name that carries a row's external key.
+const ExternalKey = "externalKey"
+
+var validate = validator.New(validator.WithRequiredStructEnabled())
+
+// entities maps the plural names used by the routes to the records they hold.
+var entities = map[string]struct {
+	name   string
+	record func() any
+}{
+	"directors":  {"director", func() any { return &database.Director{} }},
+	"actors":     {"actor", func() any { return &database.Actor{} }},
+	"films":      {"film", func() any { return &database.Film{} }},
+	"characters": {"character", func() any { return &database.Character{} }},
+}
+
+type Options struct {
+	// Entity is directors, actors, films or characters.
+	Entity string
+	// Format is csv or ndjson.
+	Format string
+	// Mapping renames CSV columns or JSON keys to the API's field names,
+	// e.g. "Director ID" to directedBy. Mapping a source to "-" skips it.
+	// Unmapped sources must already be field names.
+	Mapping map[string]string
+	// DryRun validates every row without loading anything.
+	DryRun bool
+}
+
+// ParseMapping parses "source=field" pairs as given on the command line or
+// in the map query parameter.
+func ParseMapping(pairs []string) (map[string]string, error) {
+	mapping := map[string]string{}
+	for _, pair := range pairs {
+		source, field, ok := strings.Cut(pair, "=")
+		if !ok || source == "" || field == "" {
+			return nil, fmt.Errorf("import: malformed mapping %q, want <source>=<field>", pair)
+		}
+		mapping[source] = field
+	}
+	return mapping, nil
+}
+
+type RowError struct {
+	// Line is the line of the input the row starts on.
+	Line  int    `json:"line"`
+	Field string `json:"field,omitempty"`
+	Error string `json:"error"`
+}
+
+type Report struct {
+	Entity   string     `json:"entity"`
+	DryRun   bool       `json:"dryRun"`
+	Rows     int        `json:"rows"`
+	Inserted int        `json:"inserted"`
+	Updated  int        `json:"updated"`
+	Errors   []RowError `json:"errors,omitempty"`
+}
+
+// ErrInvalidRows is returned with the report when rows failed to parse or
+// validate. Nothing is loaded in that case.
+var ErrInvalidRows = errors.New("import: some rows are invalid")
+
+// Run reads and validates every row of r and, unless this is a dry run and
+// if every row is valid, loads them all in one transaction.
+func Run(ctx context.Context, r io.Reader, opts Options) (*Report, error) {
+	entity, ok := entities[opts.Entity]
+	if !ok {
+		return nil, fmt.Errorf("import: unknown entity %q, want directors, actors, films or characters", opts.Entity)
+	}
+	rows, rowErrs, err := read(r, opts, entity.record)
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{Entity: opts.Entity, DryRun: opts.DryRun, Rows: len(rows), Errors: rowErrs}
+	if len(rowErrs) > 0 {
+		return report, ErrInvalidRows
+	}
+	if opts.DryRun || len(rows) == 0 {
+		return report, nil
+	}
+
+	report.Inserted, report.Updated, err = database.ImportRows(ctx, entity.name, rows)
+	if err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// read parses and validates the rows. It only fails outright if the input
+// cannot be read at all; problems with single rows are collected.
+func read(r io.Reader, opts Options, record func() any) ([]database.ImportRow, []RowError, error) {
+	var rows []database.ImportRow
+	var rowErrs []RowError
+	keys := map[string]int{}
+
+	add := func(line int, fields map[string]setter) {
+		rec := record()
+		var key string
+		var errs []RowError
+		for name, set := range fields {
+			target, ok := opts.Mapping[name]
+			if !ok {
+				target = name
+			}
+			switch target {
+			case "-":
+				continue
+			case ExternalKey:
+				key = set.text()
+				continue
+			}
+			if err := setField(rec, target, set); err != nil {
+				errs = append(errs, RowError{Line: line, Field: target, Error: err.Error()})
+			}
+		}
+		if len(errs) == 0 {
+			errs = validationErrors(line, validate.Struct(rec))
+		}
+		if first, ok := keys[key]; ok && key != "" {
+			errs = append(errs, RowError{Line: line, Field: ExternalKey,
+				Error: fmt.Sprintf("external key %q repeats the row on line %d", key, first)})
+		} else if key != "" {
+			keys[key] = line
+		}
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+		rowErrs = append(rowErrs, errs...)
+		rows = append(rows, database.ImportRow{ExternalKey: key, Record: rec})
+	}
+
+	var err error
+	switch opts.Format {
+	case "csv":
+		err = readCSV(r, add, func() bool { return len(rowErrs) >= maxErrors })
+	case "ndjson":
+		err = readNDJSON(r, add, func() bool { return len(rowErrs) >= maxErrors })
+	default:
+		return nil, nil, fmt.Errorf("import: unknown format %q, want csv or ndjson", opts.Format)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rowErrs) > maxErrors {
+		rowErrs = rowErrs[:maxErrors]
+	}
+	return rows, rowErrs, nil
+}
+
+func readCSV(r io.Reader, add func(int, map[string]setter), full func() bool) error {
+	cr := csv.NewReader(r)
+	// Rows with the wrong number of fields are reported like any other
+	// invalid row rather than ending the import.
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("import: reading CSV header: %w", err)
+	}
+	for !full() {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("import: %w", err)
+		}
+		line, _ := cr.FieldPos(0)
+		if len(record) != len(header) {
+			add(line, map[string]setter{"": invalidValue{fmt.Errorf("row has %d fields but the header has %d", len(record), len(header))}})
+			continue
+		}
+		fields := make(map[string]setter, len(header))
+		for i, name := range header {
+			fields[name] = csvValue(record[i])
+		}
+		add(line, fields)
+	}
+	return nil
+}
+
+func readNDJSON(r io.Reader, add func(int, map[string]setter), full func() bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan() && !full(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(text, &obj); err != nil {
+			add(line, map[string]setter{"": invalidValue{err}})
+			continue
+		}
+		fields := make(map[string]setter, len(obj))
+		for name, raw := range obj {
+			fields[name] = jsonValue(raw)
+		}
+		add(line, fields)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+	return nil
+}
+
+// setter stores a source value in a struct field of any type.
+type setter interface {
+	set(dst reflect.Value) error
+	text() string
+}
+
+type csvValue string
+
+func (v csvValue) set(dst reflect.Value) error {
+	s := strings.TrimSpace(string(v))
+	switch dst.Kind() {
+	case reflect.String:
+		dst.SetString(string(v))
+	case reflect.Int:
+		if s == "" {
+			return nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", s)
+		}
+		dst.SetInt(int64(n))
+	case reflect.Bool:
+		if s == "" {
+			return nil
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", s)
+		}
+		dst.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported field type %s", dst.Type())
+	}
+	return nil
+}
+
+func (v csvValue) text() string {
+	return strings.TrimSpace(string(v))
+}
+
+type jsonValue json.RawMessage
+
+func (v jsonValue) set(dst reflect.Value) error {
+	return json.Unmarshal(v, dst.Addr().Interface())
+}
+
+func (v jsonValue) text() string {
+	var s string
+	if json.Unmarshal(v, &s) == nil {
+		return s
+	}
+	return string(v)
+}
+
+// invalidValue stands in for a line that is not JSON at all.
+type invalidValue struct {
+	err error
+}
+
+func (v invalidValue) set(reflect.Value) error { return v.err }
+func (v invalidValue) text() string            { return "" }
+
+// setField sets the field of rec named name in JSON. The id is assigned by
+// the database and cannot be imported; map it to externalKey or "-".
+func setField(rec any, name string, value setter) error {
+	if err, ok := value.(invalidValue); ok {
+		return err.err
+	}
+	v := reflect.ValueOf(rec).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		tag, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if tag != name || tag == "-" {
+			continue
+		}
+		if tag == "id" {
+			return errors.New("ids are assigned on import; map the column to externalKey or -")
+		}
+		return value.set(v.Field(i))
+	}
+	return errors.New("unknown field")
+}
+
+func validationErrors(line int, err error) []RowError {
+	var invalid validator.ValidationErrors
+	if !errors.As(err, &invalid) {
+		if err != nil {
+			return []RowError{{Line: line, Error: err.Error()}}
+		}
+		return nil
+	}
+	errs := make([]RowError, len(invalid))
+	for i, fe := range invalid {
+		errs[i] = RowError{Line: line, Field: jsonName(fe.StructField()), Error: "failed the " + fe.Tag() + " rule"}
+	}
+	return errs
+}
+
+// jsonName turns a struct field name such as DirectedBy into its JSON name.
+func jsonName(field string) string {
+	if field == "" {
+		return ""
+	}
+	return strings.ToLower(field[:1]) + field[1:]
+}
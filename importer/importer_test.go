@@ -0,0 +1,143 @@
+package importer
+
+import (
+	"go-test/database"
+	"strings"
+	"testing"
+)
+
+func TestReadMapsRows(t *testing.T) {
+	heat := &database.Film{Title: "Heat", DirectedBy: 3, Logline: "Cops and robbers", Year: 1995}
+	for _, tt := range []struct {
+		name    string
+		format  string
+		mapping map[string]string
+		input   string
+	}{
+		{
+			name:   "csv",
+			format: "csv",
+			input:  "externalKey,title,directedBy,logline,year\nheat,Heat,3,Cops and robbers,1995\n",
+		},
+		{
+			name:    "csv with mapping",
+			format:  "csv",
+			mapping: map[string]string{"Key": ExternalKey, "Title": "title", "Director ID": "directedBy", "Notes": "-"},
+			input:   "Key,Title,Director ID,logline,year,Notes\nheat,Heat, 3 ,Cops and robbers,1995,seen twice\n",
+		},
+		{
+			name:   "ndjson",
+			format: "ndjson",
+			input:  `{"externalKey":"heat","title":"Heat","directedBy":3,"logline":"Cops and robbers","year":1995}` + "\n\n",
+		},
+		{
+			name:    "ndjson with mapping",
+			format:  "ndjson",
+			mapping: map[string]string{"ref": ExternalKey, "director": "directedBy", "rating": "-"},
+			input:   `{"ref":"heat","title":"Heat","director":3,"logline":"Cops and robbers","year":1995,"rating":8.3}` + "\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := Options{Entity: "films", Format: tt.format, Mapping: tt.mapping}
+			rows, rowErrs, err := read(strings.NewReader(tt.input), opts, entities["films"].record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rowErrs) > 0 {
+				t.Fatalf("row errors %v, want none", rowErrs)
+			}
+			if len(rows) != 1 {
+				t.Fatalf("got %d rows, want 1", len(rows))
+			}
+			if rows[0].ExternalKey != "heat" {
+				t.Errorf("external key %q, want heat", rows[0].ExternalKey)
+			}
+			if got := rows[0].Record.(*database.Film); *got != *heat {
+				t.Errorf("got %+v, want %+v", *got, *heat)
+			}
+		})
+	}
+}
+
+func TestReadReportsRows(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		format string
+		input  string
+		want   []RowError
+	}{
+		{
+			name:   "id column",
+			format: "csv",
+			input:  "id,firstName,lastName\n4,Michael,Mann\n",
+			want:   []RowError{{Line: 2, Field: "id", Error: "ids are assigned on import; map the column to externalKey or -"}},
+		},
+		{
+			name:   "unknown column",
+			format: "csv",
+			input:  "firstName,lastName,born\nMichael,Mann,1943\n",
+			want:   []RowError{{Line: 2, Field: "born", Error: "unknown field"}},
+		},
+		{
+			name:   "field count",
+			format: "csv",
+			input:  "firstName,lastName\nMichael\nKathryn,Bigelow\n",
+			want:   []RowError{{Line: 2, Error: "row has 1 fields but the header has 2"}},
+		},
+		{
+			name:   "failed rule",
+			format: "csv",
+			input:  "firstName,lastName\nMichael,\n",
+			want:   []RowError{{Line: 2, Field: "lastName", Error: "failed the required rule"}},
+		},
+		{
+			name:   "repeated external key",
+			format: "csv",
+			input:  "externalKey,firstName,lastName\nmann,Michael,Mann\nmann,Michael,Mann\n",
+			want:   []RowError{{Line: 3, Field: ExternalKey, Error: `external key "mann" repeats the row on line 2`}},
+		},
+		{
+			name:   "wrong json type",
+			format: "ndjson",
+			input:  `{"firstName":"Michael","lastName":"Mann"}` + "\n" + `{"firstName":1,"lastName":"Mann"}` + "\n",
+			want:   []RowError{{Line: 2, Field: "firstName", Error: "json: cannot unmarshal number into Go value of type string"}},
+		},
+		{
+			name:   "not json",
+			format: "ndjson",
+			input:  "\n{\"firstName\":\n",
+			want:   []RowError{{Line: 2, Error: "unexpected end of JSON input"}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := Options{Entity: "directors", Format: tt.format}
+			_, rowErrs, err := read(strings.NewReader(tt.input), opts, entities["directors"].record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rowErrs) != len(tt.want) {
+				t.Fatalf("got errors %v, want %v", rowErrs, tt.want)
+			}
+			for i := range rowErrs {
+				if rowErrs[i] != tt.want[i] {
+					t.Errorf("got %+v, want %+v", rowErrs[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseMapping(t *testing.T) {
+	mapping, err := ParseMapping([]string{"Director ID=directedBy", "Notes=-"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapping["Director ID"] != "directedBy" || mapping["Notes"] != "-" {
+		t.Errorf("got %v, want Director ID and Notes mapped", mapping)
+	}
+	for _, pair := range []string{"directedBy", "=title", "Title="} {
+		if _, err := ParseMapping([]string{pair}); err == nil {
+			t.Errorf("ParseMapping(%q) succeeded, want an error", pair)
+		}
+	}
+}
@@ -1,29 +1,27 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"go-test/auth"
+	"go-test/config"
 	"go-test/database"
+	"go-test/logging"
+	"go-test/middleware"
 	"go-test/server"
-	"log"
+	"go-test/tracing"
+	"log/slog"
 	"os"
-
-	"github.com/joho/godotenv"
+	"os/signal"
+	"strings"
+	"syscall"
 )
 
-func getDsn() string {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
-	dsn := fmt.Sprintf(
-		"postgres://%s:%s@%s:%s/%s",
-		os.Getenv("DB_USER"),
-		os.Getenv("DB_PASSWORD"),
-		os.Getenv("DB_HOST"),
-		os.Getenv("DB_PORT"),
-		os.Getenv("DB_NAME"),
-	)
-	return dsn
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
 }
 
 // @title			Movie Database
@@ -33,6 +31,127 @@ func getDsn() string {
 // @contact.email	m.pecherkin.sas@gmail.com
 // @BasePath		/
 func main() {
-	database.SetupDB(getDsn())
-	server.Setup(os.Getenv("HOST"))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			code := command(ctx, os.Args[2:])
+			stop()
+			os.Exit(code)
+		}
+	}
+
+	cfg, err := config.Load(os.Args[0], os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		os.Stderr.WriteString(err.Error() + "\n")
+		os.Exit(2)
+	}
+
+	authConfig, serverConfig, err := settings(cfg)
+	if err != nil {
+		os.Stderr.WriteString(err.Error() + "\n")
+		os.Exit(2)
+	}
+
+	err = logging.Setup(os.Stderr, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		fatal("Invalid configuration", err)
+	}
+	middleware.TrustProxy = cfg.HTTP.TrustProxy
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing.Exporter)
+	if err != nil {
+		fatal("Unable to set up tracing", err)
+	}
+	server.OnShutdown("tracing", shutdownTracing)
+
+	err = database.SetupDB(ctx, poolConfig(cfg.DB))
+	if err != nil {
+		fatal("Unable to connect to database", err)
+	}
+	server.OnShutdown("database", func(context.Context) error {
+		database.Close()
+		return nil
+	})
+	database.SetupCache(ctx, database.CacheConfig{Size: cfg.Cache.Size, TTL: cfg.Cache.TTL})
+
+	err = auth.Setup(ctx, authConfig)
+	if err != nil {
+		fatal("Unable to set up authentication", err)
+	}
+
+	if cfg.HTTP.RateLimitStore == "postgres" {
+		serverConfig.RateLimit.Store = middleware.NewPostgresStore(
+			max(serverConfig.RateLimit.Read.Per, serverConfig.RateLimit.Write.Per))
+	}
+
+	err = server.Setup(ctx, serverConfig)
+	if err != nil {
+		fatal("Server stopped with errors", err)
+	}
+	slog.Info("Server stopped")
+}
+
+// settings parses the settings that config keeps as text into the types of
+// the packages that use them, reporting every bad value by its key.
+func settings(cfg *config.Config) (auth.Config, server.Config, error) {
+	var errs []error
+	parse := func(key string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+
+	authConfig := auth.Config{
+		Issuer:        cfg.Auth.Issuer,
+		ClientID:      cfg.Auth.ClientID,
+		ClientSecret:  cfg.Auth.ClientSecret,
+		RedirectURL:   cfg.Auth.RedirectURL,
+		GroupsClaim:   cfg.Auth.GroupsClaim,
+		SessionKey:    []byte(cfg.Auth.SessionSecret),
+		SessionTTL:    cfg.Auth.SessionTTL,
+		InsecureAdmin: cfg.Auth.InsecureAdmin,
+	}
+	var err error
+	authConfig.GroupRoles, err = auth.ParseGroupRoles(cfg.Auth.GroupRoles)
+	parse("auth.group_roles", err)
+	authConfig.DefaultRole, err = auth.ParseRole(cfg.Auth.DefaultRole)
+	parse("auth.default_role", err)
+
+	serverConfig := server.Config{HTTP: cfg.HTTP}
+	serverConfig.RouteTimeouts, err = middleware.ParseRouteTimeouts(cfg.HTTP.RouteTimeouts)
+	parse("http.route_timeouts", err)
+	serverConfig.RouteCacheControl, err = middleware.ParseRouteCacheControl(cfg.HTTP.RouteCacheControl)
+	parse("http.route_cache_control", err)
+	serverConfig.RateLimit.Read, err = middleware.ParseLimit(cfg.HTTP.RateLimitRead)
+	parse("http.rate_limit_read", err)
+	serverConfig.RateLimit.Write, err = middleware.ParseLimit(cfg.HTTP.RateLimitWrite)
+	parse("http.rate_limit_write", err)
+	for _, key := range strings.Split(cfg.HTTP.RateLimitAPIKeys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			serverConfig.RateLimit.APIKeys = append(serverConfig.RateLimit.APIKeys, key)
+		}
+	}
+	return authConfig, serverConfig, errors.Join(errs...)
+}
+
+func poolConfig(db config.DB) database.PoolConfig {
+	return database.PoolConfig{
+		DSN:                db.DSN(),
+		MaxConns:           int32(db.MaxConns),
+		MinConns:           int32(db.MinConns),
+		MaxConnLifetime:    db.MaxConnLifetime,
+		MaxConnIdleTime:    db.MaxConnIdleTime,
+		HealthCheckPeriod:  db.HealthCheckPeriod,
+		StatementCacheMode: db.StatementCacheMode,
+		ApplicationName:    db.ApplicationName,
+		SearchPath:         db.SearchPath,
+		ConnectAttempts:    db.ConnectAttempts,
+		QueryLogLevel:      db.QueryLogLevel,
+		MigrationsTable:    db.MigrationsTable,
+	}
 }
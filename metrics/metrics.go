@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"go-test/database"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Duration of HTTP requests by route pattern, method and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	RequestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "Number of HTTP requests currently being served.",
+	})
+
+	Panics = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "http_panics_total",
+		Help: "Number of handler panics recovered.",
+	})
+
+	FilmsCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "films_created_total",
+		Help: "Number of films created.",
+	})
+
+	DeletesBlocked = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "deletes_blocked_total",
+		Help: "Number of deletes refused because other records still reference the entity.",
+	}, []string{"entity"})
+)
+
+func init() {
+	prometheus.MustRegister(poolCollector{}, cacheCollector{})
+}
+
+var (
+	poolAcquired = prometheus.NewDesc("db_pool_acquired_conns",
+		"Connections currently acquired from the pool.", nil, nil)
+	poolIdle = prometheus.NewDesc("db_pool_idle_conns",
+		"Idle connections in the pool.", nil, nil)
+	poolTotal = prometheus.NewDesc("db_pool_total_conns",
+		"Total connections in the pool.", nil, nil)
+	poolMax = prometheus.NewDesc("db_pool_max_conns",
+		"Maximum size of the pool.", nil, nil)
+	poolAcquires = prometheus.NewDesc("db_pool_acquires_total",
+		"Successful acquires from the pool.", nil, nil)
+	poolWaits = prometheus.NewDesc("db_pool_wait_count_total",
+		"Acquires that had to wait for a connection.", nil, nil)
+	poolWaitDuration = prometheus.NewDesc("db_pool_acquire_duration_seconds_total",
+		"Total time spent acquiring connections.", nil, nil)
+)
+
+// poolCollector reads pgxpool statistics at scrape time.
+type poolCollector struct{}
+
+func (poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolAcquired
+	ch <- poolIdle
+	ch <- poolTotal
+	ch <- poolMax
+	ch <- poolAcquires
+	ch <- poolWaits
+	ch <- poolWaitDuration
+}
+
+func (poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := database.Stat()
+	if stat == nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(poolAcquired, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdle, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolTotal, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(poolMax, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquires, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolWaits, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolWaitDuration, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
+
+var (
+	cacheHits = prometheus.NewDesc("cache_hits_total",
+		"Entity lookups answered from the cache.", []string{"entity"}, nil)
+	cacheMisses = prometheus.NewDesc("cache_misses_total",
+		"Entity lookups that went to the database.", []string{"entity"}, nil)
+	cacheEvictions = prometheus.NewDesc("cache_evictions_total",
+		"Entries evicted to make room for new ones.", []string{"entity"}, nil)
+	cacheEntries = prometheus.NewDesc("cache_entries",
+		"Entries currently cached.", []string{"entity"}, nil)
+)
+
+// cacheCollector reads the entity cache statistics at scrape time.
+type cacheCollector struct{}
+
+func (cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheHits
+	ch <- cacheMisses
+	ch <- cacheEvictions
+	ch <- cacheEntries
+}
+
+func (cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	for entity, stats := range database.CacheStats() {
+		ch <- prometheus.MustNewConstMetric(cacheHits, prometheus.CounterValue, float64(stats.Hits), entity)
+		ch <- prometheus.MustNewConstMetric(cacheMisses, prometheus.CounterValue, float64(stats.Misses), entity)
+		ch <- prometheus.MustNewConstMetric(cacheEvictions, prometheus.CounterValue, float64(stats.Evictions), entity)
+		ch <- prometheus.MustNewConstMetric(cacheEntries, prometheus.GaugeValue, float64(stats.Size), entity)
+	}
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"go-test/auth"
+	"go-test/logging"
+	"net/http"
+)
+
+// IsAuthed attaches the authenticated user, if any, to the request context.
+// It never rejects a request on its own; CheckPermissions decides that.
+func IsAuthed(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := auth.Authenticate(r)
+		if err != nil {
+			w.WriteHeader(401)
+			w.Write([]byte("Error: invalid credentials\n"))
+			logging.FromContext(r.Context()).Error("Error in IsAuthed middleware", "err", err)
+			return
+		}
+		if user != nil {
+			r = r.WithContext(auth.WithUser(r.Context(), user))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CheckPermissions enforces the role required by auth.RequiredRole.
+// While OpenID Connect is not configured it lets everything through except
+// the admin routes, which stay closed unless auth.AdminOpen says otherwise.
+func CheckPermissions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		required := auth.RequiredRole(r)
+		if required == auth.RoleNone {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !auth.Enabled() {
+			if required == auth.RoleAdmin && !auth.AdminOpen() {
+				w.WriteHeader(403)
+				w.Write([]byte("Error: admin routes require login to be configured\n"))
+				logging.FromContext(r.Context()).Warn("Admin route refused while login is disabled", "path", r.URL.Path)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user := auth.UserFromContext(r.Context())
+		if user == nil {
+			w.WriteHeader(401)
+			w.Write([]byte("Error: authentication required\n"))
+			return
+		}
+		if !user.Role.Includes(required) {
+			w.WriteHeader(403)
+			w.Write([]byte("Error: insufficient permissions\n"))
+			logging.FromContext(r.Context()).Warn("Forbidden",
+				"user", user.Subject, "role", user.Role, "required", required)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
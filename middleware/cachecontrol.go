@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// RouteCacheControl maps mux patterns such as "GET /films/{id}" to the
+// Cache-Control header of their responses.
+type RouteCacheControl map[string]string
+
+// ParseRouteCacheControl parses "GET /films/=max-age=60;GET /docs/=public,
+// max-age=3600". Entries are separated by semicolons, since directives
+// themselves contain commas and equals signs; the first "=" ends the
+// pattern.
+func ParseRouteCacheControl(s string) (RouteCacheControl, error) {
+	routes := RouteCacheControl{}
+	for _, entry := range strings.Split(s, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		pattern, directives, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(directives) == "" {
+			return nil, fmt.Errorf("route cache control %q: want <pattern>=<directives>", entry)
+		}
+		routes[strings.TrimSpace(pattern)] = strings.TrimSpace(directives)
+	}
+	return routes, nil
+}
+
+func (c *RouteCacheControl) UnmarshalText(text []byte) error {
+	routes, err := ParseRouteCacheControl(string(text))
+	if err != nil {
+		return err
+	}
+	*c = routes
+	return nil
+}
+
+type CacheControlConfig struct {
+	// Default applies to GET and HEAD routes without an entry in Routes.
+	Default string
+	Routes  RouteCacheControl
+}
+
+// CacheControl sets the Cache-Control header of GET and HEAD responses by
+// route. Handlers that set their own, such as the admin endpoints, win.
+func CacheControl(mux *http.ServeMux, cfg CacheControlConfig) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				directives, ok := cfg.Routes[routePattern(mux, r)]
+				if !ok {
+					directives = cfg.Default
+				}
+				if directives != "" {
+					w.Header().Set("Cache-Control", directives)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+type CompressConfig struct {
+	// MinSize is the smallest body worth compressing. Responses that are
+	// flushed before reaching it are compressed anyway, since a streaming
+	// response has no final size to compare.
+	MinSize int
+	// Brotli adds br to the offered encodings. It compresses best but is
+	// by far the slowest to encode.
+	Brotli bool
+}
+
+// encoder is the part of the gzip, zstd and brotli writers we use.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+type encoding struct {
+	name string
+	pool sync.Pool
+}
+
+// encodings are listed in the order preferred when the client weighs
+// several equally.
+var encodings = []*encoding{
+	{name: "zstd", pool: sync.Pool{New: func() any {
+		// Concurrency 1 keeps the encoder synchronous so Flush and Close
+		// write to the response on the handler's goroutine.
+		e, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedDefault))
+		return e
+	}}},
+	{name: "br", pool: sync.Pool{New: func() any {
+		return brotli.NewWriterLevel(nil, brotli.DefaultCompression)
+	}}},
+	{name: "gzip", pool: sync.Pool{New: func() any {
+		return gzip.NewWriter(nil)
+	}}},
+}
+
+// Compress encodes response bodies with the best of zstd, br and gzip the
+// client accepts. Small bodies, bodies that are already compressed and
+// responses that carry no body are sent as they are.
+//
+// It has to sit outside Timeout, which buffers the whole response and
+// writes it in one go, so that timeout problem responses are encoded the
+// same way as regular ones.
+func Compress(cfg CompressConfig) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			enc := negotiate(r.Header.Get("Accept-Encoding"), cfg.Brotli)
+			cw := &compressWriter{ResponseWriter: w, enc: enc, minSize: cfg.MinSize, head: r.Method == http.MethodHead}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiate picks the encoding with the highest q-value in accept, or nil
+// if none is acceptable.
+func negotiate(accept string, withBrotli bool) *encoding {
+	if accept == "" {
+		return nil
+	}
+	weights := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		weights[name] = q
+	}
+
+	var best *encoding
+	bestQ := 0.0
+	for _, e := range encodings {
+		if e.name == "br" && !withBrotli {
+			continue
+		}
+		q, ok := weights[e.name]
+		if !ok {
+			q, ok = weights["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+	return best
+}
+
+// incompressible lists media types that are compressed already.
+var incompressible = map[string]bool{
+	"application/gzip": true,
+	"application/zip":  true,
+	"application/zstd": true,
+	"font/woff":        true,
+	"font/woff2":       true,
+	"image/gif":        true,
+	"image/jpeg":       true,
+	"image/png":        true,
+	"image/webp":       true,
+}
+
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "video/") || strings.HasPrefix(mediaType, "audio/") {
+		return false
+	}
+	return !incompressible[mediaType]
+}
+
+// compressWriter holds back the first MinSize bytes of the body to decide
+// whether compressing is worth it, then either encodes everything or
+// passes it through untouched.
+type compressWriter struct {
+	http.ResponseWriter
+	enc     *encoding
+	minSize int
+	head    bool
+
+	status  int
+	buf     []byte
+	decided bool
+	w       encoder
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.decided || w.status != 0 {
+		return
+	}
+	// 1xx responses go out at once and leave the real response to come.
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+	if !bodyAllowed(status) || w.head {
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < w.minSize {
+			return len(b), nil
+		}
+		if err := w.decide(false); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.w != nil {
+		return w.w.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *compressWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.w != nil {
+		w.w.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.ErrUnsupported
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// decide sends the header, compressed or not, followed by whatever body
+// has been held back. streaming overrides the minimum size.
+func (w *compressWriter) decide(streaming bool) error {
+	w.decided = true
+	h := w.Header()
+	if h.Get("Content-Type") == "" && len(w.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+
+	candidate := bodyAllowed(w.status) && h.Get("Content-Encoding") == "" &&
+		(h.Get("Content-Type") == "" || compressible(h.Get("Content-Type")))
+	if candidate || w.status == http.StatusNotModified {
+		// The body may be encoded for other clients even when this one
+		// gets it plain, so caches must key on Accept-Encoding regardless.
+		// A 304 carries the Vary of the response it stands for.
+		addVary(h, "Accept-Encoding")
+	}
+	if candidate && w.enc != nil && !w.head && (streaming || len(w.buf) >= max(w.minSize, 1)) {
+		h.Set("Content-Encoding", w.enc.name)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		if etag := h.Get("ETag"); etag != "" {
+			h.Set("ETag", EncodedETag(etag, w.enc.name))
+		}
+		w.w = w.enc.pool.Get().(encoder)
+		w.w.Reset(w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.w != nil {
+		_, err = w.w.Write(w.buf)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+	return err
+}
+
+func (w *compressWriter) close() {
+	if !w.decided {
+		if w.status == 0 {
+			// The handler wrote nothing; leave the default response to
+			// net/http.
+			return
+		}
+		w.decide(false)
+	}
+	if w.w != nil {
+		w.w.Close()
+		w.w.Reset(nil)
+		w.enc.pool.Put(w.w)
+		w.w = nil
+	}
+}
+
+// EncodedETag returns the validator of the encoded representation. Each
+// encoding is a different representation, so a strong ETag must change
+// with it; the encoding is appended inside the quotes, as Apache does.
+func EncodedETag(etag, encoding string) string {
+	if !strings.HasSuffix(etag, `"`) || len(etag) < 2 {
+		return etag
+	}
+	return etag[:len(etag)-1] + "-" + encoding + `"`
+}
+
+// DecodedETag strips the suffix added by EncodedETag, so that validators
+// sent back by clients can be compared with the identity representation.
+func DecodedETag(etag string) string {
+	if !strings.HasSuffix(etag, `"`) {
+		return etag
+	}
+	for _, e := range encodings {
+		if trimmed, ok := strings.CutSuffix(etag, "-"+e.name+`"`); ok {
+			return trimmed + `"`
+		}
+	}
+	return etag
+}
+
+func addVary(h http.Header, field string) {
+	for _, v := range h.Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f == "*" || strings.EqualFold(f, field) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", field)
+}
+
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
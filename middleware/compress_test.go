@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	for _, tt := range []struct {
+		accept string
+		brotli bool
+		want   string
+	}{
+		{"", false, ""},
+		{"identity", false, ""},
+		{"gzip", false, "gzip"},
+		{"GZIP", false, "gzip"},
+		{"gzip, zstd", false, "zstd"},
+		{"gzip, br", false, "gzip"},
+		{"gzip, br", true, "br"},
+		{"zstd, br, gzip", true, "zstd"},
+		{"zstd;q=0.5, gzip", false, "gzip"},
+		{"zstd;q=0, gzip;q=0.1", false, "gzip"},
+		{"gzip;q=0", false, ""},
+		{"*", false, "zstd"},
+		{"*;q=0.2, gzip;q=0.5", false, "gzip"},
+		{"gzip;q=abc", false, ""},
+		{"deflate", false, ""},
+	} {
+		got := ""
+		if enc := negotiate(tt.accept, tt.brotli); enc != nil {
+			got = enc.name
+		}
+		if got != tt.want {
+			t.Errorf("negotiate(%q, brotli %v) = %q, want %q", tt.accept, tt.brotli, got, tt.want)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	long := strings.Repeat("a film about films ", 100)
+	for _, tt := range []struct {
+		name        string
+		contentType string
+		body        string
+		encoded     bool
+	}{
+		{"large text", "text/plain; charset=utf-8", long, true},
+		{"small text", "text/plain; charset=utf-8", "short", false},
+		{"already compressed", "image/png", long, false},
+		{"sniffed type", "", long, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := Compress(CompressConfig{MinSize: 64})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				io.WriteString(w, tt.body)
+			}))
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			body := w.Body.String()
+			if encoded := w.Header().Get("Content-Encoding") == "gzip"; encoded != tt.encoded {
+				t.Fatalf("Content-Encoding %q, want encoded %v", w.Header().Get("Content-Encoding"), tt.encoded)
+			}
+			if tt.encoded {
+				zr, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, err := io.ReadAll(zr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = string(b)
+			}
+			if body != tt.body {
+				t.Errorf("body %q, want %q", body, tt.body)
+			}
+			if tt.contentType != "image/png" && !strings.Contains(w.Header().Get("Vary"), "Accept-Encoding") {
+				t.Errorf("Vary %q, want Accept-Encoding", w.Header().Get("Vary"))
+			}
+		})
+	}
+}
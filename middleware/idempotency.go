@@ -0,0 +1,207 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"go-test/auth"
+	"go-test/database"
+	"go-test/logging"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// idempotencyAbandonAfter is how long a key stays claimed by a request
+// whose server went away before storing its response or releasing it.
+const idempotencyAbandonAfter = 15 * time.Minute
+
+type IdempotencyConfig struct {
+	// Window is how long a response is replayed for its key.
+	Window time.Duration
+	// MaxBody bounds the request bodies read to fingerprint them.
+	MaxBody int64
+	// Skip lists routes that set their own, larger body limits, such as
+	// uploads. They ignore Idempotency-Key instead of refusing bodies
+	// above MaxBody.
+	Skip map[string]bool
+}
+
+// unstoredHeaders describe the request being answered rather than the
+// response, so a replay keeps the current request's values.
+var unstoredHeaders = []string{
+	"Set-Cookie", "X-Request-ID", "X-Trace-ID",
+	"RateLimit-Policy", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After",
+}
+
+// Idempotency makes POST requests carrying an Idempotency-Key header safe to
+// retry. The first request with a key runs and its response is stored; a
+// repeat within the window with the same body gets the stored response
+// back, and one with a different body gets a 422. A repeat while the first
+// is still running gets a 409 with Retry-After, so waiting requests hold
+// neither a connection nor a lock.
+//
+// Keys are scoped to the user and route, so clients cannot see each
+// other's responses. Server errors are not stored, so they can be retried.
+//
+// It sits inside Timeout so that a request that outlives its deadline still
+// records the response the handler eventually produced.
+func Idempotency(mux *http.ServeMux, cfg IdempotencyConfig) Middleware {
+	go func() {
+		for range time.Tick(10 * time.Minute) {
+			err := database.PruneIdempotencyKeys(context.Background(), cfg.Window)
+			if err != nil {
+				slog.Error("Error in PruneIdempotencyKeys operation", "err", err)
+			}
+		}
+	}()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("Idempotency-Key")
+			if r.Method != http.MethodPost || key == "" || cfg.Skip[routePattern(mux, r)] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > 255 {
+				WriteProblem(w, r, Problem{
+					Status: http.StatusBadRequest,
+					Title:  "Invalid Idempotency-Key",
+					Detail: "The Idempotency-Key header must be at most 255 characters.",
+				})
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, cfg.MaxBody))
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				WriteProblem(w, r, Problem{
+					Status: http.StatusRequestEntityTooLarge,
+					Detail: fmt.Sprintf("The request body exceeds %d bytes.", maxBytesErr.Limit),
+				})
+				return
+			}
+			if err != nil {
+				w.WriteHeader(400)
+				w.Write([]byte("Error: could not read request body\n"))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			fingerprint := sha256.Sum256(append([]byte(r.Method+" "+r.URL.RequestURI()+"\n"), body...))
+
+			// The claim and the stored response must outlive the request's
+			// deadline, or a slow handler's result would be lost.
+			ctx := context.WithoutCancel(r.Context())
+			claim, stored, err := database.ClaimIdempotencyKey(ctx, idempotencyScope(r)+key, fingerprint[:], cfg.Window, idempotencyAbandonAfter)
+			if stored != nil && !bytes.Equal(stored.Fingerprint, fingerprint[:]) {
+				WriteProblem(w, r, Problem{
+					Status: http.StatusUnprocessableEntity,
+					Title:  "Idempotency-Key reused",
+					Detail: "The Idempotency-Key was already used with a different request body.",
+				})
+				return
+			}
+			if errors.Is(err, database.ErrIdempotencyKeyInUse) {
+				w.Header().Set("Retry-After", "1")
+				WriteProblem(w, r, Problem{
+					Status: http.StatusConflict,
+					Title:  "Idempotency-Key in use",
+					Detail: "A request with this Idempotency-Key is still in progress; retry it later.",
+				})
+				return
+			}
+			if err != nil {
+				w.WriteHeader(500)
+				w.Write([]byte("Error in ClaimIdempotencyKey operation\n"))
+				logging.FromContext(r.Context()).Error("Error in ClaimIdempotencyKey operation", "err", err)
+				return
+			}
+			if stored != nil {
+				for k, v := range stored.Header {
+					if !slices.Contains(unstoredHeaders, k) {
+						w.Header()[k] = v
+					}
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(stored.Status)
+				w.Write(stored.Body)
+				return
+			}
+
+			// Unless a response is stored, the claim is released so the
+			// request can be retried, also if the handler panics.
+			kept := false
+			defer func() {
+				if !kept {
+					err := claim.Release(ctx)
+					if err != nil {
+						logging.FromContext(r.Context()).Error("Error releasing idempotency key", "err", err)
+					}
+				}
+			}()
+
+			rec := &recordingWriter{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			if rec.status == 0 || rec.status >= 500 {
+				return
+			}
+			header := rec.header.Clone()
+			for _, k := range unstoredHeaders {
+				header.Del(k)
+			}
+			resp := database.StoredResponse{
+				Fingerprint: fingerprint[:],
+				Status:      rec.status,
+				Header:      header,
+				Body:        rec.body.Bytes(),
+			}
+			err = claim.Store(ctx, resp)
+			if err != nil {
+				logging.FromContext(r.Context()).Error("Error storing idempotent response", "err", err)
+				return
+			}
+			kept = true
+		})
+	}
+}
+
+// idempotencyScope keeps keys of different users and routes apart.
+func idempotencyScope(r *http.Request) string {
+	subject := ""
+	if user := auth.UserFromContext(r.Context()); user != nil {
+		subject = user.Subject
+	}
+	return subject + "|" + r.URL.Path + "|"
+}
+
+// recordingWriter passes the response through while keeping a copy.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+		w.header = w.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -1,7 +1,15 @@
 package middleware
 
 import (
-	"log"
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"go-test/logging"
+	"go-test/tracing"
+	"log/slog"
+	"net"
 	"net/http"
 	"time"
 )
@@ -10,7 +18,7 @@ type Middleware func(http.Handler) http.Handler
 
 func CreateStack(xs ...Middleware) Middleware {
 	return func(next http.Handler) http.Handler {
-		for i := len(xs)-1; i >= 0; i-- {
+		for i := len(xs) - 1; i >= 0; i-- {
 			x := xs[i]
 			next = x(next)
 		}
@@ -19,10 +27,121 @@ func CreateStack(xs ...Middleware) Middleware {
 	}
 }
 
+type requestIDKey struct{}
+
+// RequestID honors a sane incoming X-Request-ID or generates one, echoes it
+// back and stores it in the request context.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(withRequestID(r.Context(), id)))
+	})
+}
+
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Logging puts a logger carrying the request fields into the request
+// context and logs every request once it is done.
 func Logging(next http.Handler) http.Handler {
-	return http.HandlerFunc(func (w http.ResponseWriter, r *http.Request){
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		next.ServeHTTP(w, r)
-		log.Println(r.Method, r.URL.Path, time.Since(start))
+		logger := slog.Default().With(
+			slog.String("request_id", RequestIDFromContext(r.Context())),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("client_ip", ClientIP(r)),
+		)
+		if traceID := tracing.TraceID(r.Context()); traceID != "" {
+			logger = logger.With(slog.String("trace_id", traceID))
+		}
+		rw := &ResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r.WithContext(logging.WithLogger(r.Context(), logger)))
+
+		level := slog.LevelInfo
+		if rw.Status() >= 500 {
+			level = slog.LevelError
+		}
+		logger.LogAttrs(r.Context(), level, "request",
+			slog.Int("status", rw.Status()),
+			slog.Int64("bytes", rw.Bytes()),
+			slog.Duration("duration", time.Since(start)),
+		)
 	})
 }
+
+// ResponseWriter records the status code and body size of a response.
+type ResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *ResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *ResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *ResponseWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *ResponseWriter) Bytes() int64 {
+	return w.bytes
+}
+
+func (w *ResponseWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.ErrUnsupported
+}
+
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
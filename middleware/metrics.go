@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"go-test/metrics"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Metrics records request durations labeled by the route pattern the mux
+// matched rather than the raw path, so ids don't blow up label cardinality.
+func Metrics(mux *http.ServeMux) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			metrics.RequestsInFlight.Inc()
+			defer metrics.RequestsInFlight.Dec()
+
+			route := routePattern(mux, r)
+			rw := &ResponseWriter{ResponseWriter: w}
+			next.ServeHTTP(rw, r)
+			metrics.RequestDuration.
+				WithLabelValues(route, r.Method, strconv.Itoa(rw.Status())).
+				Observe(time.Since(start).Seconds())
+		})
+	}
+}
+
+// routePattern returns the mux pattern that will serve r, e.g.
+// "GET /films/{id}", or "unmatched".
+func routePattern(mux *http.ServeMux, r *http.Request) string {
+	_, pattern := mux.Handler(r)
+	if pattern == "" {
+		return "unmatched"
+	}
+	return pattern
+}
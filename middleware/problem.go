@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"encoding/json"
+	"go-test/tracing"
+	"net/http"
+)
+
+// Problem is an RFC 9457 problem details body. RequestID and TraceID let a
+// client quote the failing request to support.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+	TraceID   string `json:"traceId,omitempty"`
+	ErrorID   string `json:"errorId,omitempty"`
+	Stack     string `json:"stack,omitempty"`
+	// Field and Offset locate what was wrong in a request body.
+	Field  string `json:"field,omitempty"`
+	Offset int64  `json:"offset,omitempty"`
+}
+
+// WriteProblem fills in the request fields of p and writes it as
+// application/problem+json.
+func WriteProblem(w http.ResponseWriter, r *http.Request, p Problem) {
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	p.Instance = r.URL.Path
+	p.RequestID = RequestIDFromContext(r.Context())
+	p.TraceID = tracing.TraceID(r.Context())
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
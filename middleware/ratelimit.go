@@ -0,0 +1,244 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go-test/auth"
+	"go-test/database"
+	"go-test/logging"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit allows Requests per Per window, refilled continuously, with bursts
+// of up to Requests.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParseLimit parses limits such as "300/1m" or "20/s". An empty string
+// means no limit.
+func ParseLimit(s string) (Limit, error) {
+	if s == "" {
+		return Limit{}, nil
+	}
+	n, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("rate limit %q: want <requests>/<duration>", s)
+	}
+	requests, err := strconv.Atoi(n)
+	if err != nil || requests <= 0 {
+		return Limit{}, fmt.Errorf("rate limit %q: bad request count", s)
+	}
+	if per != "" && (per[0] < '0' || per[0] > '9') {
+		per = "1" + per
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("rate limit %q: bad duration", s)
+	}
+	return Limit{Requests: requests, Per: d}, nil
+}
+
+func (l *Limit) UnmarshalText(text []byte) error {
+	parsed, err := ParseLimit(string(text))
+	if err != nil {
+		return err
+	}
+	*l = parsed
+	return nil
+}
+
+func (l Limit) enabled() bool {
+	return l.Requests > 0
+}
+
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+type RateLimitConfig struct {
+	Read  Limit
+	Write Limit
+	Store RateLimitStore
+	// APIKeys are the keys clients may send in X-API-Key to get a bucket
+	// of their own. Any other key is ignored, so a client cannot escape
+	// its IP's bucket by inventing keys.
+	APIKeys []string
+	// Exempt lists routes that are never limited, such as probes and
+	// metrics scraped from a single address.
+	Exempt map[string]bool
+}
+
+// RateLimitStore keeps token buckets. Take refills the bucket under key,
+// takes a token if it can and returns the tokens left.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, limit Limit) (remaining float64, allowed bool, err error)
+}
+
+// RateLimit limits each client with a token bucket, keyed by the logged in
+// user, else a known API key, else the client IP. Reads and writes are counted
+// in separate buckets. If the store fails the request is let through.
+func RateLimit(mux *http.ServeMux, cfg RateLimitConfig) Middleware {
+	if cfg.Store == nil {
+		cfg.Store = NewMemoryStore()
+	}
+	apiKeys := make(map[[sha256.Size]byte]bool, len(cfg.APIKeys))
+	for _, key := range cfg.APIKeys {
+		apiKeys[sha256.Sum256([]byte(key))] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			class, limit := "write", cfg.Write
+			if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+				class, limit = "read", cfg.Read
+			}
+			if !limit.enabled() || cfg.Exempt[routePattern(mux, r)] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := class + ":" + clientIdentity(r, apiKeys)
+			remaining, allowed, err := cfg.Store.Take(r.Context(), key, limit)
+			if err != nil {
+				logging.FromContext(r.Context()).Error("Error in RateLimit middleware", "err", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			rate := limit.rate()
+			h := w.Header()
+			h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, int(math.Ceil(limit.Per.Seconds()))))
+			h.Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+			h.Set("RateLimit-Remaining", strconv.Itoa(int(math.Max(0, math.Floor(remaining)))))
+			h.Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil((float64(limit.Requests)-remaining)/rate))))
+
+			if !allowed {
+				h.Set("Retry-After", strconv.Itoa(int(math.Ceil((1-remaining)/rate))))
+				w.WriteHeader(429)
+				w.Write([]byte("Error: Too many requests!\n"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func clientIdentity(r *http.Request, apiKeys map[[sha256.Size]byte]bool) string {
+	if user := auth.UserFromContext(r.Context()); user != nil {
+		return "user:" + user.Subject
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		sum := sha256.Sum256([]byte(key))
+		if apiKeys[sum] {
+			return "key:" + hex.EncodeToString(sum[:8])
+		}
+	}
+	return "ip:" + ClientIP(r)
+}
+
+// TrustProxy makes ClientIP believe X-Forwarded-For. Only set it when the
+// server is reachable solely through a proxy that overwrites that header.
+var TrustProxy bool
+
+// ClientIP returns the address of the client, taking the left-most
+// X-Forwarded-For entry when the server runs behind a trusted proxy.
+func ClientIP(r *http.Request) string {
+	if TrustProxy {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			first, _, _ := strings.Cut(xff, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full is when the bucket will have refilled if left alone.
+	full time.Time
+}
+
+// MemoryStore keeps buckets in process memory. Buckets idle long enough to
+// be full again are dropped every minute.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{buckets: map[string]*bucket{}}
+	go s.prune(time.Minute)
+	return s
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit) (float64, bool, error) {
+	now := time.Now()
+	burst := float64(limit.Requests)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, updated: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.updated).Seconds()*limit.rate())
+	b.updated = now
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	b.full = now.Add(time.Duration((burst - b.tokens) / limit.rate() * float64(time.Second)))
+	return b.tokens, allowed, nil
+}
+
+func (s *MemoryStore) prune(every time.Duration) {
+	for range time.Tick(every) {
+		s.mu.Lock()
+		now := time.Now()
+		for key, b := range s.buckets {
+			if now.After(b.full) {
+				delete(s.buckets, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// PostgresStore keeps buckets in the rate_limits table so that every replica
+// shares them.
+type PostgresStore struct{}
+
+// NewPostgresStore prunes buckets idle for longer than idle, which must be
+// at least the longest window of the limits kept in the store so that
+// dropped buckets are full again.
+func NewPostgresStore(idle time.Duration) *PostgresStore {
+	go func() {
+		for range time.Tick(10 * time.Minute) {
+			err := database.PruneRateLimits(context.Background(), idle)
+			if err != nil {
+				slog.Error("Error in PruneRateLimits operation", "err", err)
+			}
+		}
+	}()
+	return &PostgresStore{}
+}
+
+func (PostgresStore) Take(ctx context.Context, key string, limit Limit) (float64, bool, error) {
+	return database.TakeRateLimitToken(ctx, key, limit.rate(), limit.Requests)
+}
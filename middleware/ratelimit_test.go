@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	for _, tt := range []struct {
+		in      string
+		want    Limit
+		wantErr bool
+	}{
+		{in: "", want: Limit{}},
+		{in: "300/1m", want: Limit{Requests: 300, Per: time.Minute}},
+		{in: "20/s", want: Limit{Requests: 20, Per: time.Second}},
+		{in: "5/h", want: Limit{Requests: 5, Per: time.Hour}},
+		{in: "10/500ms", want: Limit{Requests: 10, Per: 500 * time.Millisecond}},
+		{in: "300", wantErr: true},
+		{in: "0/m", wantErr: true},
+		{in: "-1/m", wantErr: true},
+		{in: "many/m", wantErr: true},
+		{in: "10/", wantErr: true},
+		{in: "10/0s", wantErr: true},
+		{in: "10/fortnight", wantErr: true},
+	} {
+		got, err := ParseLimit(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseLimit(%q) error %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseLimit(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryStoreTake(t *testing.T) {
+	s := &MemoryStore{buckets: map[string]*bucket{}}
+	limit := Limit{Requests: 3, Per: 3 * time.Second}
+	take := func() (float64, bool) {
+		remaining, allowed, err := s.Take(context.Background(), "read:ip:192.0.2.1", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return remaining, allowed
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, allowed := take(); !allowed {
+			t.Fatalf("request %d refused, want the burst of 3 allowed", i+1)
+		}
+	}
+	if _, allowed := take(); allowed {
+		t.Fatal("request 4 allowed, want the empty bucket to refuse it")
+	}
+
+	// Two seconds at one token a second refill two tokens.
+	b := s.buckets["read:ip:192.0.2.1"]
+	b.updated = b.updated.Add(-2 * time.Second)
+	if remaining, allowed := take(); !allowed || remaining < 0.99 || remaining > 1.01 {
+		t.Errorf("after refilling got %.2f tokens left and allowed %v, want 1 and true", remaining, allowed)
+	}
+	if d := b.full.Sub(b.updated); d < 1990*time.Millisecond || d > 2010*time.Millisecond {
+		t.Errorf("bucket full in %s, want 2s", d)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	mux := http.NewServeMux()
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	mux.HandleFunc("GET /films/", ok)
+	mux.HandleFunc("GET /healthz", ok)
+	limit := Limit{Requests: 1, Per: time.Minute}
+	handler := RateLimit(mux, RateLimitConfig{
+		Read:    limit,
+		Write:   limit,
+		Store:   &MemoryStore{buckets: map[string]*bucket{}},
+		APIKeys: []string{"known"},
+		Exempt:  map[string]bool{"GET /healthz": true},
+	})(mux)
+
+	for _, tt := range []struct {
+		name   string
+		method string
+		path   string
+		apiKey string
+		status int
+	}{
+		{name: "first read", method: "GET", path: "/films/", status: 200},
+		{name: "second read", method: "GET", path: "/films/", status: 429},
+		{name: "write has its own bucket", method: "POST", path: "/films/", status: 405},
+		{name: "known key has its own bucket", method: "GET", path: "/films/", apiKey: "known", status: 200},
+		{name: "unknown key shares the ip bucket", method: "GET", path: "/films/", apiKey: "invented", status: 429},
+		{name: "exempt route", method: "GET", path: "/healthz", status: 200},
+		{name: "exempt route again", method: "GET", path: "/healthz", status: 200},
+	} {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.apiKey != "" {
+			r.Header.Set("X-API-Key", tt.apiKey)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if tt.status == 429 && w.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: no Retry-After header", tt.name)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"go-test/logging"
+	"go-test/metrics"
+	"net/http"
+	"runtime/debug"
+)
+
+// PanicError carries a panic out of the goroutine it happened in together
+// with that goroutine's stack, so it is not lost when re-panicking.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprint(p.Value)
+}
+
+// Recover turns a panicking handler into a 500 problem response carrying an
+// error id, which is also logged with the stack so support can find it.
+// With dev set the stack is included in the response.
+func Recover(dev bool) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw := &ResponseWriter{ResponseWriter: w}
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+				stack := debug.Stack()
+				if pe, ok := p.(*PanicError); ok {
+					p, stack = pe.Value, pe.Stack
+				}
+
+				b := make([]byte, 8)
+				rand.Read(b)
+				errorID := hex.EncodeToString(b)
+
+				metrics.Panics.Inc()
+				logging.FromContext(r.Context()).Error("Panic in handler",
+					"panic", fmt.Sprint(p),
+					"error_id", errorID,
+					"stack", string(stack),
+				)
+				if rw.status != 0 {
+					// Part of the response is already out; all we can
+					// do is cut it short.
+					panic(http.ErrAbortHandler)
+				}
+
+				problem := Problem{
+					Status:  http.StatusInternalServerError,
+					Detail:  "An unexpected error occurred. Quote the error id when contacting support.",
+					ErrorID: errorID,
+				}
+				if dev {
+					problem.Detail = fmt.Sprint(p)
+					problem.Stack = string(stack)
+				}
+				WriteProblem(rw, r, problem)
+			}()
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RouteTimeouts maps mux patterns such as "GET /films/" to the time their
+// handlers may take.
+type RouteTimeouts map[string]time.Duration
+
+// ParseRouteTimeouts parses "GET /films/=2s,POST /batch=1m".
+func ParseRouteTimeouts(s string) (RouteTimeouts, error) {
+	timeouts := RouteTimeouts{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		pattern, d, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("route timeout %q: want <pattern>=<duration>", pair)
+		}
+		timeout, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("route timeout %q: bad duration", pair)
+		}
+		timeouts[strings.TrimSpace(pattern)] = timeout
+	}
+	return timeouts, nil
+}
+
+func (t *RouteTimeouts) UnmarshalText(text []byte) error {
+	timeouts, err := ParseRouteTimeouts(string(text))
+	if err != nil {
+		return err
+	}
+	*t = timeouts
+	return nil
+}
+
+type TimeoutConfig struct {
+	// Default applies to routes without an entry in Routes.
+	Default time.Duration
+	Routes  RouteTimeouts
+	// Streaming routes write their response as they go. They only get a
+	// context deadline, since buffering would defeat the point of
+	// streaming.
+	Streaming map[string]bool
+	// Unbounded routes, such as downloads of a whole table, only get a
+	// deadline if Routes has one for them.
+	Unbounded map[string]bool
+}
+
+// Timeout bounds each route by its configured timeout. The deadline is set
+// on the request context, so database queries are cancelled with it. The
+// response is buffered; if the deadline passes first the client gets a 503
+// problem response instead.
+func Timeout(mux *http.ServeMux, cfg TimeoutConfig) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := routePattern(mux, r)
+			timeout, ok := cfg.Routes[route]
+			if !ok && !cfg.Unbounded[route] {
+				timeout = cfg.Default
+			}
+			if cfg.Streaming[route] {
+				if timeout > 0 {
+					ctx, cancel := context.WithTimeout(r.Context(), timeout)
+					defer cancel()
+					r = r.WithContext(ctx)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			if timeout <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			serveWithTimeout(w, r, next, timeout)
+		})
+	}
+}
+
+// serveWithTimeout works like http.TimeoutHandler, but answers with a
+// problem body.
+func serveWithTimeout(w http.ResponseWriter, r *http.Request, next http.Handler, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	done := make(chan struct{})
+	panicChan := make(chan any, 1)
+	tw := &timeoutWriter{h: make(http.Header)}
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				if p != http.ErrAbortHandler {
+					p = &PanicError{Value: p, Stack: debug.Stack()}
+				}
+				panicChan <- p
+			}
+		}()
+		next.ServeHTTP(tw, r)
+		close(done)
+	}()
+
+	select {
+	case p := <-panicChan:
+		panic(p)
+	case <-done:
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		dst := w.Header()
+		for k, vv := range tw.h {
+			dst[k] = vv
+		}
+		if tw.code == 0 {
+			tw.code = http.StatusOK
+		}
+		w.WriteHeader(tw.code)
+		w.Write(tw.buf.Bytes())
+	case <-ctx.Done():
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		tw.err = http.ErrHandlerTimeout
+		if ctx.Err() == context.DeadlineExceeded {
+			WriteProblem(w, r, Problem{
+				Status: http.StatusServiceUnavailable,
+				Title:  "Request timed out",
+				Detail: fmt.Sprintf("The request did not complete within %s.", timeout),
+			})
+			return
+		}
+		// The client went away; nobody is left to read a body.
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+type timeoutWriter struct {
+	mu   sync.Mutex
+	h    http.Header
+	buf  bytes.Buffer
+	code int
+	err  error
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.h
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.err != nil {
+		return 0, tw.err
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	return tw.buf.Write(p)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.err != nil || tw.code != 0 {
+		return
+	}
+	tw.code = code
+}
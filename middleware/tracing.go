@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing starts a server span for every request, continuing the trace from
+// an incoming traceparent header, and returns the trace id in X-Trace-ID so
+// it can be quoted from any response, errors included.
+func Tracing(mux *http.ServeMux) Middleware {
+	tracer := otel.Tracer("go-test/middleware")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			route := routePattern(mux, r)
+			ctx, span := tracer.Start(ctx, route,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					semconv.HTTPRequestMethodKey.String(r.Method),
+					semconv.HTTPRoute(route),
+					semconv.URLPath(r.URL.Path),
+					semconv.ClientAddress(ClientIP(r)),
+					semconv.UserAgentOriginal(r.UserAgent()),
+				),
+			)
+			defer span.End()
+
+			if sc := span.SpanContext(); sc.HasTraceID() {
+				w.Header().Set("X-Trace-ID", sc.TraceID().String())
+			}
+
+			rw := &ResponseWriter{ResponseWriter: w}
+			next.ServeHTTP(rw, r.WithContext(ctx))
+
+			span.SetAttributes(semconv.HTTPResponseStatusCode(rw.Status()))
+			if rw.Status() >= 500 {
+				span.SetStatus(codes.Error, fmt.Sprintf("HTTP %d", rw.Status()))
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"encoding/json"
+	operations "go-test/database"
+	"go-test/logging"
+	"net/http"
+)
+
+type PoolStats struct {
+	AcquiredConns           int32   `json:"acquiredConns"`
+	IdleConns               int32   `json:"idleConns"`
+	ConstructingConns       int32   `json:"constructingConns"`
+	TotalConns              int32   `json:"totalConns"`
+	MaxConns                int32   `json:"maxConns"`
+	AcquireCount            int64   `json:"acquireCount"`
+	AcquireDurationMs       float64 `json:"acquireDurationMs"`
+	EmptyAcquireCount       int64   `json:"emptyAcquireCount"`
+	CanceledAcquireCount    int64   `json:"canceledAcquireCount"`
+	NewConnsCount           int64   `json:"newConnsCount"`
+	MaxLifetimeDestroyCount int64   `json:"maxLifetimeDestroyCount"`
+	MaxIdleDestroyCount     int64   `json:"maxIdleDestroyCount"`
+}
+
+// @Summary	Reports live connection pool statistics.
+// @Tags		Admin
+// @Produce	application/json
+// @Success	200		{object}	PoolStats
+// @Failure	403		{object}	ResponseHTTP{}
+// @Router		/admin/pool [get]
+func getPoolStats(w http.ResponseWriter, r *http.Request) {
+	stat := operations.Stat()
+	stats := PoolStats{
+		AcquiredConns:           stat.AcquiredConns(),
+		IdleConns:               stat.IdleConns(),
+		ConstructingConns:       stat.ConstructingConns(),
+		TotalConns:              stat.TotalConns(),
+		MaxConns:                stat.MaxConns(),
+		AcquireCount:            stat.AcquireCount(),
+		AcquireDurationMs:       float64(stat.AcquireDuration().Microseconds()) / 1000,
+		EmptyAcquireCount:       stat.EmptyAcquireCount(),
+		CanceledAcquireCount:    stat.CanceledAcquireCount(),
+		NewConnsCount:           stat.NewConnsCount(),
+		MaxLifetimeDestroyCount: stat.MaxLifetimeDestroyCount(),
+		MaxIdleDestroyCount:     stat.MaxIdleDestroyCount(),
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	err := json.NewEncoder(w).Encode(stats)
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("Error in getPoolStats handler\n"))
+		logging.FromContext(r.Context()).Error("Error in getPoolStats handler", "err", err)
+		return
+	}
+}
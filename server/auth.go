@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"go-test/auth"
+	"go-test/logging"
+	"net/http"
+)
+
+// @Summary	Redirects to the identity provider to log in.
+// @Tags		Auth
+// @Param		returnTo	query	string	false	"Local path to return to after login"
+// @Success	302
+// @Failure	404		{object}	ResponseHTTP{}
+// @Failure	500		{object}	ResponseHTTP{}
+// @Router		/auth/login [get]
+func login(w http.ResponseWriter, r *http.Request) {
+	url, cookie, err := auth.LoginURL(r.URL.Query().Get("returnTo"))
+	if errors.Is(err, auth.ErrDisabled) {
+		w.WriteHeader(404)
+		w.Write([]byte("Error: Login is not configured!\n"))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("Error in login handler\n"))
+		logging.FromContext(r.Context()).Error("Error in login handler", "err", err)
+		return
+	}
+
+	http.SetCookie(w, cookie)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// @Summary	Completes the login started by /auth/login.
+// @Tags		Auth
+// @Param		code	query	string	true	"Authorization code"
+// @Param		state	query	string	true	"Login state"
+// @Success	302
+// @Failure	400		{object}	ResponseHTTP{}
+// @Failure	401		{object}	ResponseHTTP{}
+// @Router		/auth/callback [get]
+func loginCallback(w http.ResponseWriter, r *http.Request) {
+	cookie, returnTo, err := auth.Exchange(r.Context(), r)
+	if errors.Is(err, auth.ErrInvalidState) || errors.Is(err, auth.ErrDisabled) {
+		w.WriteHeader(400)
+		w.Write([]byte("Error: Invalid login state!\n"))
+		logging.FromContext(r.Context()).Error("Error in loginCallback handler", "err", err)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(401)
+		w.Write([]byte("Error: Login failed!\n"))
+		logging.FromContext(r.Context()).Error("Error in loginCallback handler", "err", err)
+		return
+	}
+
+	for _, c := range auth.ClearCookies() {
+		if c.Name != cookie.Name {
+			http.SetCookie(w, c)
+		}
+	}
+	http.SetCookie(w, cookie)
+	http.Redirect(w, r, returnTo, http.StatusFound)
+}
+
+// @Summary	Clears the session cookie.
+// @Tags		Auth
+// @Success	204
+// @Router		/auth/logout [post]
+func logout(w http.ResponseWriter, r *http.Request) {
+	for _, c := range auth.ClearCookies() {
+		http.SetCookie(w, c)
+	}
+	w.WriteHeader(204)
+}
+
+// @Summary	Returns the logged in user and their role.
+// @Tags		Auth
+// @Produce	application/json
+// @Success	200		{object}	ResponseHTTP{data=auth.User}
+// @Failure	401		{object}	ResponseHTTP{}
+// @Router		/auth/me [get]
+func me(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		w.WriteHeader(401)
+		w.Write([]byte("Error: Not logged in!\n"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err := json.NewEncoder(w).Encode(user)
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("Error in me handler\n"))
+		logging.FromContext(r.Context()).Error("Error in me handler", "err", err)
+		return
+	}
+}
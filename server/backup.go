@@ -0,0 +1,95 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	operations "go-test/database"
+	"go-test/logging"
+	"go-test/middleware"
+	"net/http"
+	"time"
+)
+
+// @Summary	Downloads a backup of every table.
+// @Description	The backup is a gzipped tar archive: manifest.json with the format
+// @Description	and schema version and the checksum of each table, then one JSON
+// @Description	Lines file per table, all read from one consistent snapshot.
+// @Tags		Admin
+// @Produce	application/gzip
+// @Success	200
+// @Failure	403		{object}	ResponseHTTP{}
+// @Router		/admin/backup [get]
+func getBackup(w http.ResponseWriter, r *http.Request) {
+	sw := &streamWriter{ResponseWriter: w}
+	name := "backup-" + time.Now().UTC().Format("20060102T150405Z") + ".tar.gz"
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+
+	manifest, err := operations.Backup(r.Context(), sw)
+	if err != nil {
+		if r.Context().Err() != nil {
+			logging.FromContext(r.Context()).Info("Backup cancelled", "err", err)
+			panic(http.ErrAbortHandler)
+		}
+		logging.FromContext(r.Context()).Error("Error in Backup operation", "err", err)
+		if sw.wrote {
+			panic(http.ErrAbortHandler)
+		}
+		w.Header().Del("Content-Type")
+		w.Header().Del("Content-Disposition")
+		w.WriteHeader(500)
+		w.Write([]byte("Error in Backup operation\n"))
+		return
+	}
+	logging.FromContext(r.Context()).Info("Backup written", "schemaVersion", manifest.SchemaVersion, "createdAt", manifest.CreatedAt)
+}
+
+// @Summary	Replaces every table with the contents of a backup.
+// @Description	Rows keep their ids. Archives of another schema version, or whose
+// @Description	files fail their checksums, are refused and change nothing.
+// @Tags		Admin
+// @Accept		application/gzip
+// @Produce	application/json
+// @Success	200		{object}	database.BackupManifest
+// @Failure	403		{object}	ResponseHTTP{}
+// @Failure	409		{object}	middleware.Problem
+// @Failure	422		{object}	middleware.Problem
+// @Router		/admin/restore [post]
+func postRestore(w http.ResponseWriter, r *http.Request) {
+	extendReadDeadline(w, r)
+	manifest, err := operations.Restore(r.Context(), r.Body)
+	var archiveErr *operations.ArchiveError
+	var mismatchErr *operations.SchemaMismatchError
+	switch {
+	case errors.As(err, &archiveErr):
+		middleware.WriteProblem(w, r, middleware.Problem{
+			Status: http.StatusUnprocessableEntity,
+			Title:  "Invalid backup archive",
+			Detail: archiveErr.Error(),
+		})
+		return
+	case errors.As(err, &mismatchErr):
+		middleware.WriteProblem(w, r, middleware.Problem{
+			Status: http.StatusConflict,
+			Title:  "Incompatible schema version",
+			Detail: mismatchErr.Error(),
+		})
+		return
+	case err != nil:
+		w.WriteHeader(500)
+		w.Write([]byte("Error in Restore operation\n"))
+		logging.FromContext(r.Context()).Error("Error in Restore operation", "err", err)
+		return
+	}
+	logging.FromContext(r.Context()).Info("Backup restored", "schemaVersion", manifest.SchemaVersion, "createdAt", manifest.CreatedAt)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(manifest)
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("Error in postRestore handler\n"))
+		logging.FromContext(r.Context()).Error("Error in postRestore handler", "err", err)
+		return
+	}
+}
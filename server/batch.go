@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	operations "go-test/database"
+	"go-test/logging"
+	"go-test/middleware"
+	"net/http"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// maxBatchOperations bounds the operations of one POST /batch.
+var maxBatchOperations = 1000
+
+type BatchRequest struct {
+	Operations []operations.BatchOperation `json:"operations"`
+}
+
+type BatchResult struct {
+	Index  int    `json:"index"`
+	Status int    `json:"status"`
+	ID     int    `json:"id,omitempty"`
+	Record any    `json:"record,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	Atomic  bool          `json:"atomic"`
+	Results []BatchResult `json:"results"`
+}
+
+// @Summary	Runs many creates, updates and deletes in one request.
+// @Description	Operations run in order. An operation with a ref can be referred
+// @Description	to by later ones as "$ref" in place of an id. By default the batch
+// @Description	is atomic: all operations succeed or none does. With atomic=false
+// @Description	each operation stands alone and gets its own status.
+// @Tags		Batch
+// @Accept		application/json
+// @Produce	application/json
+// @Param		Batch	body		BatchRequest	true	"Operations to run"
+// @Param		atomic	query		bool			false	"Run all operations in one transaction (default true)"
+// @Success	200		{object}	BatchResponse
+// @Failure	400		{object}	middleware.Problem
+// @Failure	404		{object}	middleware.Problem
+// @Failure	409		{object}	middleware.Problem
+// @Failure	413		{object}	middleware.Problem
+// @Failure	422		{object}	middleware.Problem
+// @Router		/batch [post]
+func postBatch(w http.ResponseWriter, r *http.Request) {
+	atomic := r.URL.Query().Get("atomic") != "false"
+
+	var req BatchRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if len(req.Operations) == 0 {
+		middleware.WriteProblem(w, r, middleware.Problem{
+			Status: http.StatusUnprocessableEntity,
+			Detail: "The batch has no operations.",
+		})
+		return
+	}
+	if len(req.Operations) > maxBatchOperations {
+		middleware.WriteProblem(w, r, middleware.Problem{
+			Status: http.StatusRequestEntityTooLarge,
+			Detail: fmt.Sprintf("A batch may hold at most %d operations, not %d.", maxBatchOperations, len(req.Operations)),
+		})
+		return
+	}
+
+	results, err := operations.RunBatch(r.Context(), req.Operations, atomic, validate.Struct)
+	var batchErr *operations.BatchError
+	if errors.As(err, &batchErr) {
+		status, detail := batchStatus(batchErr.Err), batchErr.Err.Error()
+		if status == http.StatusInternalServerError {
+			detail = "internal error"
+			logging.FromContext(r.Context()).Error("Error in RunBatch operation", "err", err)
+		}
+		middleware.WriteProblem(w, r, middleware.Problem{
+			Status: status,
+			Title:  "Batch rolled back",
+			Detail: fmt.Sprintf("Operation %d failed, so none was applied: %s", batchErr.Index, detail),
+			Field:  fmt.Sprintf("operations[%d]", batchErr.Index),
+		})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("Error in RunBatch operation\n"))
+		logging.FromContext(r.Context()).Error("Error in RunBatch operation", "err", err)
+		return
+	}
+
+	resp := BatchResponse{Atomic: atomic, Results: make([]BatchResult, len(results))}
+	for i, result := range results {
+		resp.Results[i] = BatchResult{Index: i, Status: http.StatusOK, ID: result.ID, Record: result.Record}
+		if req.Operations[i].Op == "create" {
+			resp.Results[i].Status = http.StatusCreated
+		}
+		if result.Err != nil {
+			resp.Results[i] = BatchResult{Index: i, Status: batchStatus(result.Err), Error: result.Err.Error()}
+		}
+		if resp.Results[i].Status == http.StatusInternalServerError {
+			resp.Results[i].Error = "internal error"
+			logging.FromContext(r.Context()).Error("Error in batch operation", "index", i, "err", result.Err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("Error in postBatch handler\n"))
+		logging.FromContext(r.Context()).Error("Error in postBatch handler", "err", err)
+		return
+	}
+}
+
+// batchStatus is the HTTP status an operation would have had on its own.
+// Creates and updates naming a missing record fail with a ReferenceError;
+// a foreign key violation left over is a delete of a referenced record.
+func batchStatus(err error) int {
+	var inputErr *operations.BatchInputError
+	var refErr *operations.ReferenceError
+	switch {
+	case errors.As(err, &inputErr), errors.As(err, &refErr):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, pgx.ErrNoRows):
+		return http.StatusNotFound
+	case operations.IsForeignKeyViolation(err):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"fmt"
+	operations "go-test/database"
+	"go-test/middleware"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// entityETag is a strong validator for a single row. updated_at changes on
+// every write, so it works as a row version.
+func entityETag(kind string, id int, updatedAt time.Time) string {
+	return fmt.Sprintf(`"%s-%d-%x"`, kind, id, updatedAt.UnixMicro())
+}
+
+// listETag is a weak validator for a list: the same version always yields
+// the same rows, but not necessarily byte for byte in the same order.
+func listETag(kind string, v operations.ListVersion) string {
+	return fmt.Sprintf(`W/"%s-%d-%x"`, kind, v.Count, v.LastModified.UnixMicro())
+}
+
+// notModified sets the validators of the representation and answers 304
+// if the request's preconditions show the client already has it. As in
+// RFC 9110, If-Modified-Since is ignored when If-None-Match is present.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		matched := matchETag(inm, etag)
+		if matched == "" {
+			return false
+		}
+		// Echo the tag the client holds; it may carry the content coding
+		// suffix added by the compression middleware.
+		w.Header().Set("ETag", matched)
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(ims)
+		if err != nil || lastModified.Truncate(time.Second).After(since) {
+			return false
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// matchETag returns the entry of an If-None-Match list that weakly matches
+// etag, or "" if none does.
+func matchETag(ifNoneMatch, etag string) string {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return etag
+	}
+	want := opaqueTag(etag)
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if opaqueTag(middleware.DecodedETag(candidate)) == want {
+			return candidate
+		}
+	}
+	return ""
+}
+
+func opaqueTag(etag string) string {
+	return strings.TrimPrefix(etag, "W/")
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-test/middleware"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// maxBodyBytes bounds the JSON bodies read by decodeJSON.
+var maxBodyBytes int64 = 1 << 20
+
+// decodeJSON strictly decodes the request body into dst. The body must be
+// declared as JSON, fit in maxBodyBytes, hold exactly one value and name
+// only fields dst has. On failure it writes a problem response saying where
+// the body went wrong and returns false.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst any) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+		w.Header().Set("Accept", "application/json")
+		middleware.WriteProblem(w, r, middleware.Problem{
+			Status: http.StatusUnsupportedMediaType,
+			Detail: "The request body must be sent as application/json.",
+		})
+		return false
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		middleware.WriteProblem(w, r, decodeProblem(err, 0))
+		return false
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(dst)
+	offset := dec.InputOffset()
+	if err == nil {
+		// Anything but the end of the body after the value is an error,
+		// whether it is a second value or garbage.
+		var extra json.RawMessage
+		if err = dec.Decode(&extra); err == io.EOF {
+			err = checkFieldCase(body, reflect.TypeOf(dst))
+		} else if err == nil {
+			err = errTrailingData
+		}
+	}
+	if err == nil {
+		return true
+	}
+	middleware.WriteProblem(w, r, decodeProblem(err, offset))
+	return false
+}
+
+var errTrailingData = errors.New("trailing data")
+
+func decodeProblem(err error, offset int64) middleware.Problem {
+	p := middleware.Problem{Status: http.StatusBadRequest, Title: "Malformed request body", Offset: offset}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var maxBytesErr *http.MaxBytesError
+	var caseErr *fieldCaseError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return middleware.Problem{
+			Status: http.StatusRequestEntityTooLarge,
+			Detail: fmt.Sprintf("The request body exceeds %d bytes.", maxBytesErr.Limit),
+		}
+	case errors.As(err, &syntaxErr):
+		p.Offset = syntaxErr.Offset
+		p.Detail = fmt.Sprintf("Invalid JSON at byte %d: %s.", syntaxErr.Offset, syntaxErr.Error())
+	case errors.As(err, &typeErr):
+		p.Field = typeErr.Field
+		p.Offset = typeErr.Offset
+		p.Detail = fmt.Sprintf("Field %q must be of JSON type %s, not %s (byte %d).",
+			typeErr.Field, jsonType(typeErr.Type.Kind()), typeErr.Value, typeErr.Offset)
+	case errors.Is(err, io.EOF):
+		p.Detail = "The request body is empty."
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		p.Detail = fmt.Sprintf("The request body ends in the middle of a JSON value (byte %d).", offset)
+	case errors.Is(err, errTrailingData):
+		p.Detail = fmt.Sprintf("The request body must hold a single JSON value, but more follows at byte %d.", offset)
+	case errors.As(err, &caseErr):
+		p.Field = caseErr.field
+		p.Offset = caseErr.offset
+		p.Detail = fmt.Sprintf("Unknown field %q (byte %d); did you mean %q?", caseErr.field, caseErr.offset, caseErr.want)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no error type for this one.
+		p.Field = strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		p.Detail = fmt.Sprintf("Unknown field %q (byte %d).", p.Field, offset)
+	default:
+		p.Detail = err.Error()
+	}
+	return p
+}
+
+// jsonType names a Go kind the way a JSON client thinks of it.
+func jsonType(kind reflect.Kind) string {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return kind.String()
+}
+
+// fieldCaseError reports a field that only matches when ignoring case,
+// which encoding/json accepts silently.
+type fieldCaseError struct {
+	field, want string
+	offset      int64
+}
+
+func (e *fieldCaseError) Error() string {
+	return fmt.Sprintf("field %q should be %q", e.field, e.want)
+}
+
+// checkFieldCase walks a JSON document that already decoded into t and
+// makes sure every object key names its struct field exactly.
+func checkFieldCase(body []byte, t reflect.Type) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	return walkFieldCase(dec, t)
+}
+
+func walkFieldCase(dec *json.Decoder, t reflect.Type) error {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok {
+	case json.Delim('{'):
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			var next reflect.Type
+			switch {
+			case t == nil:
+			case t.Kind() == reflect.Map:
+				next = t.Elem()
+			case t.Kind() == reflect.Struct:
+				f, exact := jsonField(t, key.(string))
+				if !exact {
+					return &fieldCaseError{field: key.(string), want: f.name, offset: dec.InputOffset()}
+				}
+				next = f.typ
+			}
+			if err := walkFieldCase(dec, next); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+	case json.Delim('['):
+		var elem reflect.Type
+		if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			elem = t.Elem()
+		}
+		for dec.More() {
+			if err := walkFieldCase(dec, elem); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+	}
+	return err
+}
+
+type structField struct {
+	name string
+	typ  reflect.Type
+}
+
+// jsonField finds the field of struct t that encoding/json decodes key
+// into, and reports whether key spells its name exactly. Keys that match
+// no field at all were rejected by DisallowUnknownFields already.
+func jsonField(t reflect.Type, key string) (structField, bool) {
+	var folded structField
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if name == key {
+			return structField{name, f.Type}, true
+		}
+		if strings.EqualFold(name, key) && folded.name == "" {
+			folded = structField{name, f.Type}
+		}
+	}
+	if folded.name == "" {
+		return structField{}, true
+	}
+	return folded, false
+}
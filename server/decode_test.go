@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	operations "go-test/database"
+	"go-test/middleware"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		field       string
+		detail      string
+	}{
+		{name: "valid", body: `{"title":"Heat","directedBy":1,"logline":"Cops and robbers","year":1995}`},
+		{name: "json suffix", contentType: "application/merge-patch+json", body: `{"title":"Heat"}`},
+		{name: "charset", contentType: "application/json; charset=utf-8", body: `{"year":1995}`},
+		{name: "not json", contentType: "text/plain", body: `{}`, status: 415},
+		{name: "no content type", contentType: "-", body: `{}`, status: 415},
+		{name: "empty", body: ``, status: 400, detail: "empty"},
+		{name: "syntax", body: `{"title":}`, status: 400, detail: "Invalid JSON at byte 10"},
+		{name: "truncated", body: `{"title":"Heat"`, status: 400, detail: "ends in the middle"},
+		{name: "wrong type", body: `{"year":"1995"}`, status: 400, field: "year", detail: "JSON type number, not string"},
+		{name: "unknown field", body: `{"director":1}`, status: 400, field: "director"},
+		{name: "field case", body: `{"Title":"Heat"}`, status: 400, field: "Title", detail: `did you mean "title"`},
+		{name: "second value", body: `{"title":"Heat"} {"title":"Ronin"}`, status: 400, detail: "single JSON value"},
+		{name: "trailing garbage", body: `{"title":"Heat"} x`, status: 400},
+		{name: "too large", body: `{"logline":"` + strings.Repeat("a", 100) + `"}`, status: 413},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(n int64) { maxBodyBytes = n }(maxBodyBytes)
+			maxBodyBytes = 100
+
+			r := httptest.NewRequest("POST", "/films/", strings.NewReader(tt.body))
+			switch tt.contentType {
+			case "":
+				r.Header.Set("Content-Type", "application/json")
+			case "-":
+			default:
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			var film operations.Film
+			ok := decodeJSON(w, r, &film)
+
+			if tt.status == 0 {
+				if !ok {
+					t.Fatalf("decodeJSON failed: %s", w.Body)
+				}
+				return
+			}
+			if ok {
+				t.Fatalf("decodeJSON accepted %s", tt.body)
+			}
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+			var p middleware.Problem
+			if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+				t.Fatalf("response is not a problem: %v", err)
+			}
+			if p.Field != tt.field {
+				t.Errorf("field %q, want %q", p.Field, tt.field)
+			}
+			if !strings.Contains(p.Detail, tt.detail) {
+				t.Errorf("detail %q, want it to mention %q", p.Detail, tt.detail)
+			}
+		})
+	}
+}
@@ -0,0 +1,390 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	operations "go-test/database"
+	"go-test/logging"
+	"go-test/middleware"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportFlushRows is how many rows an export writes between flushes, so
+// clients see progress and the response is never held back whole.
+const exportFlushRows = 1000
+
+// @Summary	Streams all directors, actors, films or characters as NDJSON or CSV.
+// @Description	Rows are read from a database cursor and written as they come, in
+// @Description	id order, with updatedAt as RFC 3339 in UTC. A filter[field]
+// @Description	parameter keeps the rows whose field equals its value, e.g.
+// @Description	filter[year]=1999; each field may be filtered once. Other query
+// @Description	parameters are ignored.
+// @Tags		Export
+// @Produce	application/x-ndjson
+// @Produce	text/csv
+// @Param		entity			path		string	true	"directors, actors, films or characters"
+// @Param		format			query		string	false	"ndjson (default) or csv"
+// @Param		updatedSince	query		string	false	"Only rows changed at or after this RFC 3339 time"
+// @Param		filter[field]	query		string	false	"Only rows whose field equals this value"
+// @Success	200
+// @Failure	400				{object}	middleware.Problem
+// @Failure	404				{object}	middleware.Problem
+// @Router		/export/{entity} [get]
+func getExport(w http.ResponseWriter, r *http.Request) {
+	entity := r.PathValue("entity")
+	columns, ok := operations.ExportColumns(entity)
+	if !ok {
+		middleware.WriteProblem(w, r, middleware.Problem{
+			Status: http.StatusNotFound,
+			Detail: fmt.Sprintf("There is no entity %q to export; try directors, actors, films or characters.", entity),
+		})
+		return
+	}
+	format, filter, ok := exportParams(w, r)
+	if !ok || !exportFilters(w, r, filter) {
+		return
+	}
+
+	ew := &streamWriter{ResponseWriter: w}
+	w = ew
+	w.Header().Set("Content-Type", exportContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, entity, format))
+	w.Header().Set("Cache-Control", "no-store")
+	rc := http.NewResponseController(w)
+	out := newRowWriter(format, w, columns)
+	err := operations.Export(r.Context(), entity, filter, func(values []any) error {
+		return out.row(values, rc.Flush)
+	})
+	if err == nil {
+		err = out.close()
+	}
+	exportFailed(ew, r, err)
+}
+
+// @Summary	Exports a consistent snapshot of the whole catalog as one archive.
+// @Description	The archive holds directors, actors, films and characters, one
+// @Description	NDJSON or CSV file each, all read in a single REPEATABLE READ
+// @Description	transaction so references between them always resolve. Filters
+// @Description	are refused; they only apply to /export/{entity}.
+// @Tags		Export
+// @Produce	application/zip
+// @Produce	application/x-tar
+// @Param		archive			query		string	false	"zip (default) or tar"
+// @Param		format			query		string	false	"ndjson (default) or csv"
+// @Param		updatedSince	query		string	false	"Only rows changed at or after this RFC 3339 time"
+// @Success	200
+// @Failure	400				{object}	middleware.Problem
+// @Router		/export [get]
+func getExportSnapshot(w http.ResponseWriter, r *http.Request) {
+	archive := r.URL.Query().Get("archive")
+	if archive == "" {
+		archive = "zip"
+	}
+	if archive != "zip" && archive != "tar" {
+		middleware.WriteProblem(w, r, middleware.Problem{
+			Status: http.StatusBadRequest,
+			Detail: fmt.Sprintf("Unknown archive %q; use zip or tar.", archive),
+		})
+		return
+	}
+	format, filter, ok := exportParams(w, r)
+	if !ok {
+		return
+	}
+	for param := range r.URL.Query() {
+		if strings.HasPrefix(param, "filter[") {
+			middleware.WriteProblem(w, r, middleware.Problem{
+				Status: http.StatusBadRequest,
+				Detail: fmt.Sprintf("%s cannot narrow a snapshot of the whole catalog; export the entity on its own.", param),
+			})
+			return
+		}
+	}
+
+	name := "catalog-" + time.Now().UTC().Format("20060102T150405Z")
+	ew := &streamWriter{ResponseWriter: w}
+	w = ew
+	w.Header().Set("Content-Type", exportContentTypes[archive])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, archive))
+	w.Header().Set("Cache-Control", "no-store")
+	err := operations.Snapshot(r.Context(), func(e *operations.Exporter) error {
+		if archive == "zip" {
+			return writeZipSnapshot(r, w, e, format, filter)
+		}
+		return writeTarSnapshot(r, w, e, format, filter)
+	})
+	exportFailed(ew, r, err)
+}
+
+var exportContentTypes = map[string]string{
+	"ndjson": "application/x-ndjson",
+	"csv":    "text/csv; charset=utf-8",
+	"zip":    "application/zip",
+	"tar":    "application/x-tar",
+}
+
+// exportParams reads the format and updatedSince parameters shared by the
+// exports, writing a problem response if they are invalid.
+func exportParams(w http.ResponseWriter, r *http.Request) (string, operations.ExportFilter, bool) {
+	filter := operations.ExportFilter{Fields: map[string]string{}}
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	if format != "ndjson" && format != "csv" {
+		middleware.WriteProblem(w, r, middleware.Problem{
+			Status: http.StatusBadRequest,
+			Detail: fmt.Sprintf("Unknown format %q; use ndjson or csv.", format),
+		})
+		return "", filter, false
+	}
+	if since := query.Get("updatedSince"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			middleware.WriteProblem(w, r, middleware.Problem{
+				Status: http.StatusBadRequest,
+				Detail: fmt.Sprintf("updatedSince %q is not an RFC 3339 time like 2024-01-02T15:04:05Z.", since),
+			})
+			return "", filter, false
+		}
+		filter.UpdatedSince = t
+	}
+	return format, filter, true
+}
+
+// exportFilters adds the filter[field]=value parameters of r to filter.
+// A field filtered more than once is refused rather than guessed at.
+func exportFilters(w http.ResponseWriter, r *http.Request, filter operations.ExportFilter) bool {
+	for name, values := range r.URL.Query() {
+		field, ok := strings.CutPrefix(name, "filter[")
+		if !ok {
+			continue
+		}
+		field, ok = strings.CutSuffix(field, "]")
+		if !ok || field == "" {
+			middleware.WriteProblem(w, r, middleware.Problem{
+				Status: http.StatusBadRequest,
+				Detail: fmt.Sprintf("Malformed filter %q; use filter[field]=value.", name),
+			})
+			return false
+		}
+		if len(values) > 1 {
+			middleware.WriteProblem(w, r, middleware.Problem{
+				Status: http.StatusBadRequest,
+				Detail: fmt.Sprintf("filter[%s] is given %d times; filter each field once.", field, len(values)),
+				Field:  field,
+			})
+			return false
+		}
+		filter.Fields[field] = values[0]
+	}
+	return true
+}
+
+// exportFailed reports err from an export written to w. Before any row
+// went out the client gets a proper error response; after that all that is
+// left is to cut the response short so the client cannot mistake it for
+// complete.
+func exportFailed(w *streamWriter, r *http.Request, err error) {
+	if err == nil {
+		return
+	}
+	if r.Context().Err() != nil {
+		logging.FromContext(r.Context()).Info("Export cancelled", "err", err)
+		panic(http.ErrAbortHandler)
+	}
+	var filterErr *operations.FilterError
+	if errors.As(err, &filterErr) && !w.wrote {
+		w.Header().Del("Content-Disposition")
+		middleware.WriteProblem(w, r, middleware.Problem{
+			Status: http.StatusBadRequest,
+			Detail: filterErr.Error(),
+			Field:  filterErr.Field,
+		})
+		return
+	}
+	logging.FromContext(r.Context()).Error("Error in Export operation", "err", err)
+	if w.wrote {
+		panic(http.ErrAbortHandler)
+	}
+	w.Header().Del("Content-Disposition")
+	w.Header().Del("Content-Type")
+	w.WriteHeader(500)
+	w.Write([]byte("Error in Export operation\n"))
+}
+
+func writeZipSnapshot(r *http.Request, w http.ResponseWriter, e *operations.Exporter, format string, filter operations.ExportFilter) error {
+	rc := http.NewResponseController(w)
+	zw := zip.NewWriter(w)
+	for _, entity := range operations.ExportEntities {
+		columns, _ := operations.ExportColumns(entity)
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     entity + "." + format,
+			Method:   zip.Deflate,
+			Modified: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		out := newRowWriter(format, f, columns)
+		err = e.Export(r.Context(), entity, filter, func(values []any) error {
+			return out.row(values, rc.Flush)
+		})
+		if err == nil {
+			err = out.close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// writeTarSnapshot spools each file to disk first, since a tar header
+// needs the size of the file it precedes.
+func writeTarSnapshot(r *http.Request, w http.ResponseWriter, e *operations.Exporter, format string, filter operations.ExportFilter) error {
+	tw := tar.NewWriter(w)
+	for _, entity := range operations.ExportEntities {
+		columns, _ := operations.ExportColumns(entity)
+		spool, err := os.CreateTemp("", "export-*."+format)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+
+		out := newRowWriter(format, spool, columns)
+		err = e.Export(r.Context(), entity, filter, func(values []any) error {
+			return out.row(values, nil)
+		})
+		if err == nil {
+			err = out.close()
+		}
+		if err != nil {
+			return err
+		}
+		size, err := spool.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		if _, err = spool.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Name:    entity + "." + format,
+			Mode:    0o644,
+			Size:    size,
+			ModTime: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(tw, spool); err != nil {
+			return err
+		}
+		http.NewResponseController(w).Flush()
+	}
+	return tw.Close()
+}
+
+// rowWriter encodes exported rows as NDJSON or CSV. Both encode values the
+// same way the API does, except that times are always UTC.
+type rowWriter struct {
+	columns []operations.ExportColumn
+	w       io.Writer
+	csv     *csv.Writer
+	line    []byte
+	record  []string
+	rows    int
+}
+
+func newRowWriter(format string, w io.Writer, columns []operations.ExportColumn) *rowWriter {
+	rw := &rowWriter{columns: columns, w: w}
+	if format == "csv" {
+		rw.csv = csv.NewWriter(w)
+		rw.record = make([]string, len(columns))
+		for i, c := range columns {
+			rw.record[i] = c.Field
+		}
+		rw.csv.Write(rw.record)
+	}
+	return rw
+}
+
+// row writes one row, calling flush every exportFlushRows rows.
+func (rw *rowWriter) row(values []any, flush func() error) error {
+	if rw.csv != nil {
+		for i, v := range values {
+			rw.record[i] = csvField(v)
+		}
+		if err := rw.csv.Write(rw.record); err != nil {
+			return err
+		}
+	} else {
+		rw.line = append(rw.line[:0], '{')
+		for i, v := range values {
+			if i > 0 {
+				rw.line = append(rw.line, ',')
+			}
+			rw.line = strconv.AppendQuote(rw.line, rw.columns[i].Field)
+			rw.line = append(rw.line, ':')
+			if t, ok := v.(time.Time); ok {
+				v = t.UTC()
+			}
+			b, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			rw.line = append(rw.line, b...)
+		}
+		rw.line = append(rw.line, '}', '\n')
+		if _, err := rw.w.Write(rw.line); err != nil {
+			return err
+		}
+	}
+
+	rw.rows++
+	if rw.rows%exportFlushRows != 0 || flush == nil {
+		return nil
+	}
+	if rw.csv != nil {
+		rw.csv.Flush()
+		if err := rw.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if err := flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+func (rw *rowWriter) close() error {
+	if rw.csv == nil {
+		return nil
+	}
+	rw.csv.Flush()
+	return rw.csv.Error()
+}
+
+func csvField(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/json"
+	operations "go-test/database"
+	"go-test/middleware"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExportFilters(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		query  string
+		want   map[string]string
+		field  string
+		detail string
+	}{
+		{name: "none", query: "format=csv", want: map[string]string{}},
+		{
+			name:  "fields",
+			query: "format=ndjson&filter[directedBy]=3&filter[year]=1995&updatedSince=2024-05-01T00:00:00Z",
+			want:  map[string]string{"directedBy": "3", "year": "1995"},
+		},
+		{name: "repeated", query: "filter[year]=1995&filter[year]=1996", field: "year", detail: "given 2 times"},
+		{name: "unclosed", query: "filter[year=1995", detail: "Malformed filter"},
+		{name: "empty name", query: "filter[]=1995", detail: "Malformed filter"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/export/films?"+tt.query, nil)
+			w := httptest.NewRecorder()
+			filter := operations.ExportFilter{Fields: map[string]string{}}
+			ok := exportFilters(w, r, filter)
+
+			if tt.want != nil {
+				if !ok {
+					t.Fatalf("exportFilters refused %s: %s", tt.query, w.Body)
+				}
+				if len(filter.Fields) != len(tt.want) {
+					t.Fatalf("fields %v, want %v", filter.Fields, tt.want)
+				}
+				for field, value := range tt.want {
+					if filter.Fields[field] != value {
+						t.Errorf("filter[%s] = %q, want %q", field, filter.Fields[field], value)
+					}
+				}
+				return
+			}
+			if ok {
+				t.Fatalf("exportFilters accepted %s", tt.query)
+			}
+			if w.Code != 400 {
+				t.Errorf("status %d, want 400", w.Code)
+			}
+			var p middleware.Problem
+			if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+				t.Fatalf("response is not a problem: %v", err)
+			}
+			if p.Field != tt.field || !strings.Contains(p.Detail, tt.detail) {
+				t.Errorf("field %q and detail %q, want %q and a detail mentioning %q", p.Field, p.Detail, tt.field, tt.detail)
+			}
+		})
+	}
+}
@@ -2,8 +2,10 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	operations "go-test/database"
-	"log"
+	"go-test/logging"
+	"go-test/metrics"
 	"net/http"
 
 	"github.com/jackc/pgx/v5"
@@ -20,6 +22,7 @@ type ResponseHTTP struct {
 // @Accept		application/json
 // @Produce	application/json
 // @Param		Director	body		database.Director	true	"Create Director record"
+// @Param		Idempotency-Key	header	string	false	"Key that makes retries of this request replay its first response"
 // @Success	200		{object}	ResponseHTTP{data=database.Director}
 // @Failure	400		{object}	ResponseHTTP{}
 // @Failure	418		{object}	ResponseHTTP{}
@@ -27,27 +30,23 @@ type ResponseHTTP struct {
 // @Router		/directors/ [post]
 func postDirector(w http.ResponseWriter, r *http.Request) {
 	var director operations.Director
-	err := json.NewDecoder(r.Body).Decode(&director)
-	if err != nil {
-		w.WriteHeader(400)
-		w.Write([]byte("Error in postDirector handler\n"))
-		log.Printf("Error in postDirector handler \n%s", err)
+	if !decodeJSON(w, r, &director) {
 		return
 	}
 
-	err = validate.Struct(director)
+	err := validate.Struct(director)
 	if err != nil {
 		w.WriteHeader(422)
 		w.Write([]byte("Error in postDirector handler \n" + err.Error()))
-		log.Printf("Error in postDirector handler \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in postDirector handler", "err", err)
 		return
 	}
 
-	newDirector, err := operations.CreateDirector(director)
+	newDirector, err := operations.CreateDirector(r.Context(), director)
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in CreateDirector operation\n"))
-		log.Printf("Error in CreateDirector operation \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in CreateDirector operation", "err", err)
 		return
 	}
 
@@ -56,7 +55,7 @@ func postDirector(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in postDirector operation\n"))
-		log.Printf("Error in postDirector operation \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in postDirector operation", "err", err)
 		return
 	}
 }
@@ -74,17 +73,21 @@ func postDirector(w http.ResponseWriter, r *http.Request) {
 func getDirectorById(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 
-	director, err := operations.FindFirstDirector(id)
+	director, err := operations.FindFirstDirector(r.Context(), id)
 	if err == pgx.ErrNoRows {
 		w.WriteHeader(404)
 		w.Write([]byte("Error: Director not found!\n"))
-		log.Printf("Error: Director not found!\n%s", err)
+		logging.FromContext(r.Context()).Info("Director not found")
 		return
 	}
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in FindFirstDirector operation\n"))
-		log.Printf("Error in FindFirstDirector operation \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in FindFirstDirector operation", "err", err)
+		return
+	}
+
+	if notModified(w, r, entityETag("director", director.ID, director.UpdatedAt), director.UpdatedAt) {
 		return
 	}
 
@@ -93,7 +96,7 @@ func getDirectorById(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in getDirectorById operation\n"))
-		log.Printf("Error in getDirectorById operation \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in getDirectorById operation", "err", err)
 		return
 	}
 }
@@ -108,28 +111,20 @@ func getDirectorById(w http.ResponseWriter, r *http.Request) {
 // @Failure	500		{object}	ResponseHTTP{}
 // @Router		/directors/ [get]
 func getDirectors(w http.ResponseWriter, r *http.Request) {
-	director, err := operations.FindDirectors()
-	if err == pgx.ErrNoRows {
-		w.WriteHeader(404)
-		w.Write([]byte("Error: Directors not found!\n"))
-		log.Printf("Error: Directors not found!\n%s", err)
-		return
-	}
+	version, err := operations.DirectorsVersion(r.Context())
 	if err != nil {
 		w.WriteHeader(500)
-		w.Write([]byte("Error in FindDirectors operation\n"))
-		log.Printf("Error in FindDirectors operation \n%s", err)
+		w.Write([]byte("Error in DirectorsVersion operation\n"))
+		logging.FromContext(r.Context()).Error("Error in DirectorsVersion operation", "err", err)
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	err = json.NewEncoder(w).Encode(director)
-	if err != nil {
-		w.WriteHeader(500)
-		w.Write([]byte("Error in getDirectors operation\n"))
-		log.Printf("Error in getDirectors operation \n%s", err)
+	if notModified(w, r, listETag("directors", version), version.LastModified) {
 		return
 	}
+
+	writeList(w, r, "EachDirector", func(fn func(*operations.Director) error) error {
+		return operations.EachDirector(r.Context(), fn)
+	})
 }
 
 // @Summary	Updates a Director record.
@@ -144,33 +139,29 @@ func getDirectors(w http.ResponseWriter, r *http.Request) {
 // @Router		/directors/ [patch]
 func patchDirector(w http.ResponseWriter, r *http.Request) {
 	var director operations.Director
-	err := json.NewDecoder(r.Body).Decode(&director)
-	if err != nil {
-		w.WriteHeader(400)
-		w.Write([]byte("Error in patchDirector handler\n"))
-		log.Printf("Error in patchDirector handler \n%s", err)
+	if !decodeJSON(w, r, &director) {
 		return
 	}
 
-	err = validate.Struct(director)
+	err := validate.Struct(director)
 	if err != nil {
 		w.WriteHeader(422)
 		w.Write([]byte("Error in patchDirector handler \n" + err.Error()))
-		log.Printf("Error in patchDirector handler \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in patchDirector handler", "err", err)
 		return
 	}
 
-	updDirector, err := operations.UpdateDirector(director)
+	updDirector, err := operations.UpdateDirector(r.Context(), director)
 	if err == pgx.ErrNoRows {
 		w.WriteHeader(404)
 		w.Write([]byte("Error: Directors not found!\n"))
-		log.Printf("Error: Directors not found!\n%s", err)
+		logging.FromContext(r.Context()).Info("Directors not found")
 		return
 	}
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in UpdateDirector operation\n"))
-		log.Printf("Error in UpdateDirector operation \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in UpdateDirector operation", "err", err)
 		return
 	}
 
@@ -179,7 +170,7 @@ func patchDirector(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in patchDirector handler\n"))
-		log.Printf("Error in patchDirector handler \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in patchDirector handler", "err", err)
 		return
 	}
 }
@@ -191,23 +182,31 @@ func patchDirector(w http.ResponseWriter, r *http.Request) {
 // @Param		id	path		string	true	"Delete a director record by ID"
 // @Success	200		{object}	ResponseHTTP{data=database.Director}
 // @Failure	400		{object}	ResponseHTTP{}
+// @Failure	409		{object}	ResponseHTTP{}
 // @Failure	418		{object}	ResponseHTTP{}
 // @Failure	500		{object}	ResponseHTTP{}
 // @Router		/directors/{id} [delete]
 func deleteDirector(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 
-	err := operations.DeleteDirector(id)
+	err := operations.DeleteDirector(r.Context(), id)
+	if operations.IsForeignKeyViolation(err) {
+		metrics.DeletesBlocked.WithLabelValues("director").Inc()
+		w.WriteHeader(409)
+		w.Write([]byte("Error: Director is still referenced by other records!\n"))
+		logging.FromContext(r.Context()).Info("Director is still referenced by other records", "err", err)
+		return
+	}
 	if err == pgx.ErrNoRows {
 		w.WriteHeader(404)
 		w.Write([]byte("Error: Director not found!\n"))
-		log.Printf("Error: Director not found!\n%s", err)
+		logging.FromContext(r.Context()).Info("Director not found")
 		return
 	}
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in FindFirstDirector operation\n"))
-		log.Printf("Error in FindFirstDirector operation \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in FindFirstDirector operation", "err", err)
 		return
 	}
 }
@@ -217,6 +216,7 @@ func deleteDirector(w http.ResponseWriter, r *http.Request) {
 // @Accept		application/json
 // @Produce	application/json
 // @Param		Actor	body		database.Actor	true	"Create Actor record"
+// @Param		Idempotency-Key	header	string	false	"Key that makes retries of this request replay its first response"
 // @Success	200		{object}	ResponseHTTP{data=database.Actor}
 // @Failure	400		{object}	ResponseHTTP{}
 // @Failure	418		{object}	ResponseHTTP{}
@@ -224,27 +224,23 @@ func deleteDirector(w http.ResponseWriter, r *http.Request) {
 // @Router		/actors/ [post]
 func postActor(w http.ResponseWriter, r *http.Request) {
 	var actor operations.Actor
-	err := json.NewDecoder(r.Body).Decode(&actor)
-	if err != nil {
-		w.WriteHeader(400)
-		w.Write([]byte("Error in postActor handler\n"))
-		log.Printf("Error in postActor handler \n%s", err)
+	if !decodeJSON(w, r, &actor) {
 		return
 	}
 
-	err = validate.Struct(actor)
+	err := validate.Struct(actor)
 	if err != nil {
 		w.WriteHeader(422)
 		w.Write([]byte("Error in postActor handler \n" + err.Error()))
-		log.Printf("Error in postActor handler \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in postActor handler", "err", err)
 		return
 	}
 
-	newActor, err := operations.CreateActor(actor)
+	newActor, err := operations.CreateActor(r.Context(), actor)
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in CreateActor operation\n"))
-		log.Printf("Error in CreateActor operation \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in CreateActor operation", "err", err)
 		return
 	}
 
@@ -253,7 +249,7 @@ func postActor(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in postActor operation\n"))
-		log.Printf("Error in postActor operation \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in postActor operation", "err", err)
 		return
 	}
 }
@@ -271,17 +267,21 @@ func postActor(w http.ResponseWriter, r *http.Request) {
 func getActorById(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 
-	actor, err := operations.FindFirstActor(id)
+	actor, err := operations.FindFirstActor(r.Context(), id)
 	if err == pgx.ErrNoRows {
 		w.WriteHeader(404)
 		w.Write([]byte("Error: Actor not found!\n"))
-		log.Printf("Error: Actor not found!\n%s", err)
+		logging.FromContext(r.Context()).Info("Actor not found")
 		return
 	}
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in FindFirstActor operation\n"))
-		log.Printf("Error in FindFirstActor operation \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in FindFirstActor operation", "err", err)
+		return
+	}
+
+	if notModified(w, r, entityETag("actor", actor.ID, actor.UpdatedAt), actor.UpdatedAt) {
 		return
 	}
 
@@ -290,7 +290,7 @@ func getActorById(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in getActorById operation\n"))
-		log.Printf("Error in getActorById operation \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in getActorById operation", "err", err)
 		return
 	}
 }
@@ -305,28 +305,20 @@ func getActorById(w http.ResponseWriter, r *http.Request) {
 // @Failure	500		{object}	ResponseHTTP{}
 // @Router		/actors/ [get]
 func getActors(w http.ResponseWriter, r *http.Request) {
-	actor, err := operations.FindActors()
-	if err == pgx.ErrNoRows {
-		w.WriteHeader(404)
-		w.Write([]byte("Error: Actors not found!\n"))
-		log.Printf("Error: Actors not found!\n%s", err)
-		return
-	}
+	version, err := operations.ActorsVersion(r.Context())
 	if err != nil {
 		w.WriteHeader(500)
-		w.Write([]byte("Error in FindActors operation\n"))
-		log.Printf("Error in FindActors operation \n%s", err)
+		w.Write([]byte("Error in ActorsVersion operation\n"))
+		logging.FromContext(r.Context()).Error("Error in ActorsVersion operation", "err", err)
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	err = json.NewEncoder(w).Encode(actor)
-	if err != nil {
-		w.WriteHeader(500)
-		w.Write([]byte("Error in getActors operation\n"))
-		log.Printf("Error in getActors operation \n%s", err)
+	if notModified(w, r, listETag("actors", version), version.LastModified) {
 		return
 	}
+
+	writeList(w, r, "EachActor", func(fn func(*operations.Actor) error) error {
+		return operations.EachActor(r.Context(), fn)
+	})
 }
 
 // @Summary	Updates a Actor record.
@@ -341,33 +333,29 @@ func getActors(w http.ResponseWriter, r *http.Request) {
 // @Router		/actors/ [patch]
 func patchActor(w http.ResponseWriter, r *http.Request) {
 	var actor operations.Actor
-	err := json.NewDecoder(r.Body).Decode(&actor)
-	if err != nil {
-		w.WriteHeader(400)
-		w.Write([]byte("Error in patchActor handler\n"))
-		log.Printf("Error in patchActor handler \n%s", err)
+	if !decodeJSON(w, r, &actor) {
 		return
 	}
 
-	err = validate.Struct(actor)
+	err := validate.Struct(actor)
 	if err != nil {
 		w.WriteHeader(422)
 		w.Write([]byte("Error in patchActor handler \n" + err.Error()))
-		log.Printf("Error in patchActor handler \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in patchActor handler", "err", err)
 		return
 	}
 
-	updActor, err := operations.UpdateActor(actor)
+	updActor, err := operations.UpdateActor(r.Context(), actor)
 	if err == pgx.ErrNoRows {
 		w.WriteHeader(404)
 		w.Write([]byte("Error: Actors not found!\n"))
-		log.Printf("Error: Actors not found!\n%s", err)
+		logging.FromContext(r.Context()).Info("Actors not found")
 		return
 	}
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in UpdateActor operation\n"))
-		log.Printf("Error in UpdateActor operation \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in UpdateActor operation", "err", err)
 		return
 	}
 
@@ -376,7 +364,7 @@ func patchActor(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in patchActor handler\n"))
-		log.Printf("Error in patchActor handler \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in patchActor handler", "err", err)
 		return
 	}
 }
@@ -388,69 +376,97 @@ func patchActor(w http.ResponseWriter, r *http.Request) {
 // @Param		id	path		string	true	"Delete a actor record by ID"
 // @Success	200		{object}	ResponseHTTP{data=database.Actor}
 // @Failure	400		{object}	ResponseHTTP{}
+// @Failure	409		{object}	ResponseHTTP{}
 // @Failure	418		{object}	ResponseHTTP{}
 // @Failure	500		{object}	ResponseHTTP{}
 // @Router		/actors/{id} [delete]
 func deleteActor(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 
-	err := operations.DeleteActor(id)
+	err := operations.DeleteActor(r.Context(), id)
+	if operations.IsForeignKeyViolation(err) {
+		metrics.DeletesBlocked.WithLabelValues("actor").Inc()
+		w.WriteHeader(409)
+		w.Write([]byte("Error: Actor is still referenced by other records!\n"))
+		logging.FromContext(r.Context()).Info("Actor is still referenced by other records", "err", err)
+		return
+	}
 	if err == pgx.ErrNoRows {
 		w.WriteHeader(404)
 		w.Write([]byte("Error: Actor not found!\n"))
-		log.Printf("Error: Actor not found!\n%s", err)
+		logging.FromContext(r.Context()).Info("Actor not found")
 		return
 	}
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in FindFirstActor operation\n"))
-		log.Printf("Error in FindFirstActor operation \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in FindFirstActor operation", "err", err)
 		return
 	}
 }
 
 // @Summary	Creates a new film record.
+// @Description	The director and characters may be given inline, in which case
+// @Description	they are created with the film in one transaction and the
+// @Description	film is returned expanded with them.
 // @Tags		Films
 // @Accept		application/json
 // @Produce	application/json
-// @Param		Film	body		database.Film	true	"Create Film record"
-// @Success	200		{object}	ResponseHTTP{data=database.Film}
+// @Param		Film	body		database.NewFilm	true	"Create Film record"
+// @Param		Idempotency-Key	header	string	false	"Key that makes retries of this request replay its first response"
+// @Success	200		{object}	ResponseHTTP{data=database.FilmDocument}
 // @Failure	400		{object}	ResponseHTTP{}
 // @Failure	418		{object}	ResponseHTTP{}
+// @Failure	422		{object}	ResponseHTTP{}
 // @Failure	500		{object}	ResponseHTTP{}
 // @Router		/films/ [post]
 func postFilm(w http.ResponseWriter, r *http.Request) {
-	var film operations.Film
-	err := json.NewDecoder(r.Body).Decode(&film)
-	if err != nil {
-		w.WriteHeader(400)
-		w.Write([]byte("Error in postFilm handler\n"))
-		log.Printf("Error in postFilm handler \n%s", err)
+	var film operations.NewFilm
+	if !decodeJSON(w, r, &film) {
 		return
 	}
 
-	err = validate.Struct(film)
+	err := validate.Struct(film)
 	if err != nil {
 		w.WriteHeader(422)
 		w.Write([]byte("Error in postFilm handler \n" + err.Error()))
-		log.Printf("Error in postFilm handler \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in postFilm handler", "err", err)
 		return
 	}
 
-	newFilm, err := operations.CreateFilm(film)
+	// Without inline records the response stays the plain film.
+	var created any
+	if film.Director == nil && film.Characters == nil {
+		created, err = operations.CreateFilm(r.Context(), operations.Film{
+			Title:      film.Title,
+			DirectedBy: film.DirectedBy,
+			Logline:    film.Logline,
+			Year:       film.Year,
+		})
+	} else {
+		created, err = operations.CreateFilmDocument(r.Context(), film)
+	}
+	var refErr *operations.ReferenceError
+	if errors.As(err, &refErr) {
+		w.WriteHeader(422)
+		w.Write([]byte("Error: " + refErr.Error() + "\n"))
+		logging.FromContext(r.Context()).Info("Film references a missing record", "err", err)
+		return
+	}
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in CreateFilm operation\n"))
-		log.Printf("Error in CreateFilm operation \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in CreateFilm operation", "err", err)
 		return
 	}
+	metrics.FilmsCreated.Inc()
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	err = json.NewEncoder(w).Encode(newFilm)
+	err = json.NewEncoder(w).Encode(created)
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in postFilm operation\n"))
-		log.Printf("Error in postFilm operation \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in postFilm operation", "err", err)
 		return
 	}
 }
@@ -468,17 +484,21 @@ func postFilm(w http.ResponseWriter, r *http.Request) {
 func getFilmById(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 
-	film, err := operations.FindFirstFilm(id)
+	film, err := operations.FindFirstFilm(r.Context(), id)
 	if err == pgx.ErrNoRows {
 		w.WriteHeader(404)
 		w.Write([]byte("Error: Film not found!\n"))
-		log.Printf("Error: Film not found!\n%s", err)
+		logging.FromContext(r.Context()).Info("Film not found")
 		return
 	}
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in FindFirstFilm operation\n"))
-		log.Printf("Error in FindFirstFilm operation \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in FindFirstFilm operation", "err", err)
+		return
+	}
+
+	if notModified(w, r, entityETag("film", film.ID, film.UpdatedAt), film.UpdatedAt) {
 		return
 	}
 
@@ -487,7 +507,7 @@ func getFilmById(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in getFilmById operation\n"))
-		log.Printf("Error in getFilmById operation \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in getFilmById operation", "err", err)
 		return
 	}
 }
@@ -502,28 +522,20 @@ func getFilmById(w http.ResponseWriter, r *http.Request) {
 // @Failure	500		{object}	ResponseHTTP{}
 // @Router		/films/ [get]
 func getFilms(w http.ResponseWriter, r *http.Request) {
-	film, err := operations.FindFilms()
-	if err == pgx.ErrNoRows {
-		w.WriteHeader(404)
-		w.Write([]byte("Error: Films not found!\n"))
-		log.Printf("Error: Films not found!\n%s", err)
-		return
-	}
+	version, err := operations.FilmsVersion(r.Context())
 	if err != nil {
 		w.WriteHeader(500)
-		w.Write([]byte("Error in FindFilms operation\n"))
-		log.Printf("Error in FindFilms operation \n%s", err)
+		w.Write([]byte("Error in FilmsVersion operation\n"))
+		logging.FromContext(r.Context()).Error("Error in FilmsVersion operation", "err", err)
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	err = json.NewEncoder(w).Encode(film)
-	if err != nil {
-		w.WriteHeader(500)
-		w.Write([]byte("Error in getFilms operation\n"))
-		log.Printf("Error in getFilms operation \n%s", err)
+	if notModified(w, r, listETag("films", version), version.LastModified) {
 		return
 	}
+
+	writeList(w, r, "EachFilm", func(fn func(*operations.Film) error) error {
+		return operations.EachFilm(r.Context(), fn)
+	})
 }
 
 // @Summary	Updates a Film record.
@@ -534,37 +546,41 @@ func getFilms(w http.ResponseWriter, r *http.Request) {
 // @Success	200		{object}	ResponseHTTP{data=database.Film}
 // @Failure	400		{object}	ResponseHTTP{}
 // @Failure	418		{object}	ResponseHTTP{}
+// @Failure	422		{object}	ResponseHTTP{}
 // @Failure	500		{object}	ResponseHTTP{}
 // @Router		/films/ [patch]
 func patchFilm(w http.ResponseWriter, r *http.Request) {
 	var film operations.Film
-	err := json.NewDecoder(r.Body).Decode(&film)
-	if err != nil {
-		w.WriteHeader(400)
-		w.Write([]byte("Error in patchFilm handler\n"))
-		log.Printf("Error in patchFilm handler \n%s", err)
+	if !decodeJSON(w, r, &film) {
 		return
 	}
 
-	err = validate.Struct(film)
+	err := validate.Struct(film)
 	if err != nil {
 		w.WriteHeader(422)
 		w.Write([]byte("Error in patchFilm handler \n" + err.Error()))
-		log.Printf("Error in patchFilm handler \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in patchFilm handler", "err", err)
 		return
 	}
 
-	updFilm, err := operations.UpdateFilm(film)
+	updFilm, err := operations.UpdateFilm(r.Context(), film)
+	var refErr *operations.ReferenceError
+	if errors.As(err, &refErr) {
+		w.WriteHeader(422)
+		w.Write([]byte("Error: " + refErr.Error() + "\n"))
+		logging.FromContext(r.Context()).Info("Film references a missing record", "err", err)
+		return
+	}
 	if err == pgx.ErrNoRows {
 		w.WriteHeader(404)
 		w.Write([]byte("Error: Films not found!\n"))
-		log.Printf("Error: Films not found!\n%s", err)
+		logging.FromContext(r.Context()).Info("Films not found")
 		return
 	}
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in UpdateFilm operation\n"))
-		log.Printf("Error in UpdateFilm operation \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in UpdateFilm operation", "err", err)
 		return
 	}
 
@@ -573,7 +589,7 @@ func patchFilm(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in patchFilm handler\n"))
-		log.Printf("Error in patchFilm handler \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in patchFilm handler", "err", err)
 		return
 	}
 }
@@ -585,23 +601,31 @@ func patchFilm(w http.ResponseWriter, r *http.Request) {
 // @Param		id	path		string	true	"Delete a film record by ID"
 // @Success	200		{object}	ResponseHTTP{data=database.Film}
 // @Failure	400		{object}	ResponseHTTP{}
+// @Failure	409		{object}	ResponseHTTP{}
 // @Failure	418		{object}	ResponseHTTP{}
 // @Failure	500		{object}	ResponseHTTP{}
 // @Router		/films/{id} [delete]
 func deleteFilm(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 
-	err := operations.DeleteFilm(id)
+	err := operations.DeleteFilm(r.Context(), id)
+	if operations.IsForeignKeyViolation(err) {
+		metrics.DeletesBlocked.WithLabelValues("film").Inc()
+		w.WriteHeader(409)
+		w.Write([]byte("Error: Film is still referenced by other records!\n"))
+		logging.FromContext(r.Context()).Info("Film is still referenced by other records", "err", err)
+		return
+	}
 	if err == pgx.ErrNoRows {
 		w.WriteHeader(404)
 		w.Write([]byte("Error: Film not found!\n"))
-		log.Printf("Error: Film not found!\n%s", err)
+		logging.FromContext(r.Context()).Info("Film not found")
 		return
 	}
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in FindFirstFilm operation\n"))
-		log.Printf("Error in FindFirstFilm operation \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in FindFirstFilm operation", "err", err)
 		return
 	}
 }
@@ -611,34 +635,39 @@ func deleteFilm(w http.ResponseWriter, r *http.Request) {
 // @Accept		application/json
 // @Produce	application/json
 // @Param		Character	body		database.Character	true	"Create Character record"
+// @Param		Idempotency-Key	header	string	false	"Key that makes retries of this request replay its first response"
 // @Success	200		{object}	ResponseHTTP{data=database.Character}
 // @Failure	400		{object}	ResponseHTTP{}
 // @Failure	418		{object}	ResponseHTTP{}
+// @Failure	422		{object}	ResponseHTTP{}
 // @Failure	500		{object}	ResponseHTTP{}
 // @Router		/characters/ [post]
 func postCharacter(w http.ResponseWriter, r *http.Request) {
 	var character operations.Character
-	err := json.NewDecoder(r.Body).Decode(&character)
-	if err != nil {
-		w.WriteHeader(400)
-		w.Write([]byte("Error in postCharacter handler\n"))
-		log.Printf("Error in postCharacter handler \n%s", err)
+	if !decodeJSON(w, r, &character) {
 		return
 	}
 
-	err = validate.Struct(character)
+	err := validate.Struct(character)
 	if err != nil {
 		w.WriteHeader(422)
 		w.Write([]byte("Error in postCharacter handler \n" + err.Error()))
-		log.Printf("Error in postCharacter handler \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in postCharacter handler", "err", err)
 		return
 	}
 
-	newCharacter, err := operations.CreateCharacter(character)
+	newCharacter, err := operations.CreateCharacter(r.Context(), character)
+	var refErr *operations.ReferenceError
+	if errors.As(err, &refErr) {
+		w.WriteHeader(422)
+		w.Write([]byte("Error: " + refErr.Error() + "\n"))
+		logging.FromContext(r.Context()).Info("Character references a missing record", "err", err)
+		return
+	}
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in CreateCharacter operation\n"))
-		log.Printf("Error in CreateCharacter operation \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in CreateCharacter operation", "err", err)
 		return
 	}
 
@@ -647,7 +676,7 @@ func postCharacter(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in postCharacter operation\n"))
-		log.Printf("Error in postCharacter operation \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in postCharacter operation", "err", err)
 		return
 	}
 }
@@ -665,17 +694,21 @@ func postCharacter(w http.ResponseWriter, r *http.Request) {
 func getCharacterById(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 
-	character, err := operations.FindFirstCharacter(id)
+	character, err := operations.FindFirstCharacter(r.Context(), id)
 	if err == pgx.ErrNoRows {
 		w.WriteHeader(404)
 		w.Write([]byte("Error: Character not found!\n"))
-		log.Printf("Error: Character not found!\n%s", err)
+		logging.FromContext(r.Context()).Info("Character not found")
 		return
 	}
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in FindFirstCharacter operation\n"))
-		log.Printf("Error in FindFirstCharacter operation \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in FindFirstCharacter operation", "err", err)
+		return
+	}
+
+	if notModified(w, r, entityETag("character", character.ID, character.UpdatedAt), character.UpdatedAt) {
 		return
 	}
 
@@ -684,7 +717,7 @@ func getCharacterById(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in getCharacterById operation\n"))
-		log.Printf("Error in getCharacterById operation \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in getCharacterById operation", "err", err)
 		return
 	}
 }
@@ -699,28 +732,20 @@ func getCharacterById(w http.ResponseWriter, r *http.Request) {
 // @Failure	500		{object}	ResponseHTTP{}
 // @Router		/characters/ [get]
 func getCharacters(w http.ResponseWriter, r *http.Request) {
-	character, err := operations.FindCharacters()
-	if err == pgx.ErrNoRows {
-		w.WriteHeader(404)
-		w.Write([]byte("Error: Characters not found!\n"))
-		log.Printf("Error: Characters not found!\n%s", err)
-		return
-	}
+	version, err := operations.CharactersVersion(r.Context())
 	if err != nil {
 		w.WriteHeader(500)
-		w.Write([]byte("Error in FindCharacters operation\n"))
-		log.Printf("Error in FindCharacters operation \n%s", err)
+		w.Write([]byte("Error in CharactersVersion operation\n"))
+		logging.FromContext(r.Context()).Error("Error in CharactersVersion operation", "err", err)
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	err = json.NewEncoder(w).Encode(character)
-	if err != nil {
-		w.WriteHeader(500)
-		w.Write([]byte("Error in getCharacters operation\n"))
-		log.Printf("Error in getCharacters operation \n%s", err)
+	if notModified(w, r, listETag("characters", version), version.LastModified) {
 		return
 	}
+
+	writeList(w, r, "EachCharacter", func(fn func(*operations.Character) error) error {
+		return operations.EachCharacter(r.Context(), fn)
+	})
 }
 
 // @Summary	Fetches character record by film id.
@@ -736,28 +761,20 @@ func getCharacters(w http.ResponseWriter, r *http.Request) {
 func getCharacterByFilmId(w http.ResponseWriter, r *http.Request) {
 	filmId := r.PathValue("filmId")
 
-	character, err := operations.FindCharactersByFilm(filmId)
-	if err == pgx.ErrNoRows {
-		w.WriteHeader(404)
-		w.Write([]byte("Error: Characters not found!\n"))
-		log.Printf("Error: Characters not found!\n%s", err)
-		return
-	}
+	version, err := operations.CharactersByFilmVersion(r.Context(), filmId)
 	if err != nil {
 		w.WriteHeader(500)
-		w.Write([]byte("Error in FindFirstCharacter operation\n"))
-		log.Printf("Error in FindFirstCharacter operation \n%s", err)
+		w.Write([]byte("Error in CharactersByFilmVersion operation\n"))
+		logging.FromContext(r.Context()).Error("Error in CharactersByFilmVersion operation", "err", err)
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	err = json.NewEncoder(w).Encode(character)
-	if err != nil {
-		w.WriteHeader(500)
-		w.Write([]byte("Error in getCharacterById operation\n"))
-		log.Printf("Error in getCharacterById operation \n%s", err)
+	if notModified(w, r, listETag("characters", version), version.LastModified) {
 		return
 	}
+
+	writeList(w, r, "EachCharacterOfFilm", func(fn func(*operations.Character) error) error {
+		return operations.EachCharacterOfFilm(r.Context(), filmId, fn)
+	})
 }
 
 // @Summary	Updates a Character record.
@@ -768,37 +785,41 @@ func getCharacterByFilmId(w http.ResponseWriter, r *http.Request) {
 // @Success	200		{object}	ResponseHTTP{data=database.Character}
 // @Failure	400		{object}	ResponseHTTP{}
 // @Failure	418		{object}	ResponseHTTP{}
+// @Failure	422		{object}	ResponseHTTP{}
 // @Failure	500		{object}	ResponseHTTP{}
 // @Router		/characters/ [patch]
 func patchCharacter(w http.ResponseWriter, r *http.Request) {
 	var character operations.Character
-	err := json.NewDecoder(r.Body).Decode(&character)
-	if err != nil {
-		w.WriteHeader(400)
-		w.Write([]byte("Error in patchCharacter handler\n"))
-		log.Printf("Error in patchCharacter handler \n%s", err)
+	if !decodeJSON(w, r, &character) {
 		return
 	}
 
-	err = validate.Struct(character)
+	err := validate.Struct(character)
 	if err != nil {
 		w.WriteHeader(422)
 		w.Write([]byte("Error in patchCharacter handler \n" + err.Error()))
-		log.Printf("Error in patchCharacter handler \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in patchCharacter handler", "err", err)
 		return
 	}
 
-	updCharacter, err := operations.UpdateCharacter(character)
+	updCharacter, err := operations.UpdateCharacter(r.Context(), character)
+	var refErr *operations.ReferenceError
+	if errors.As(err, &refErr) {
+		w.WriteHeader(422)
+		w.Write([]byte("Error: " + refErr.Error() + "\n"))
+		logging.FromContext(r.Context()).Info("Character references a missing record", "err", err)
+		return
+	}
 	if err == pgx.ErrNoRows {
 		w.WriteHeader(404)
 		w.Write([]byte("Error: Characters not found!\n"))
-		log.Printf("Error: Characters not found!\n%s", err)
+		logging.FromContext(r.Context()).Info("Characters not found")
 		return
 	}
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in UpdateCharacter operation\n"))
-		log.Printf("Error in UpdateCharacter operation \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in UpdateCharacter operation", "err", err)
 		return
 	}
 
@@ -807,7 +828,7 @@ func patchCharacter(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in patchCharacter handler\n"))
-		log.Printf("Error in patchCharacter handler \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in patchCharacter handler", "err", err)
 		return
 	}
 }
@@ -825,17 +846,17 @@ func patchCharacter(w http.ResponseWriter, r *http.Request) {
 func deleteCharacter(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 
-	err := operations.DeleteCharacter(id)
+	err := operations.DeleteCharacter(r.Context(), id)
 	if err == pgx.ErrNoRows {
 		w.WriteHeader(404)
 		w.Write([]byte("Error: Character not found!\n"))
-		log.Printf("Error: Character not found!\n%s", err)
+		logging.FromContext(r.Context()).Info("Character not found")
 		return
 	}
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("Error in FindFirstCharacter operation\n"))
-		log.Printf("Error in FindFirstCharacter operation \n%s", err)
+		logging.FromContext(r.Context()).Error("Error in FindFirstCharacter operation", "err", err)
 		return
 	}
 }
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	operations "go-test/database"
+	"go-test/logging"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// draining is set once shutdown starts so that readiness fails and the load
+// balancer stops sending traffic before the listener closes.
+var draining atomic.Bool
+
+type CheckResult struct {
+	// Status is ok, warn or fail. Only failures fail readiness.
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latencyMs"`
+	Detail    string  `json:"detail,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+type check func(ctx context.Context) (detail string, err error)
+
+// warning is a check error worth reporting that should not take the
+// replica out of rotation.
+type warning struct{ error }
+
+var readinessChecks = map[string]check{
+	"database": func(ctx context.Context) (string, error) {
+		return "", operations.Ping(ctx)
+	},
+	"schema": func(ctx context.Context) (string, error) {
+		version, err := operations.SchemaVersion(ctx)
+		if err != nil {
+			return "", err
+		}
+		expected := operations.ExpectedSchemaVersion()
+		detail := fmt.Sprintf("version %d, expected %d", version, expected)
+		if version != expected {
+			return detail, fmt.Errorf("schema version mismatch")
+		}
+		return detail, nil
+	},
+	"pool": func(ctx context.Context) (string, error) {
+		stat := operations.Stat()
+		saturation := float64(stat.AcquiredConns()) / float64(stat.MaxConns())
+		detail := fmt.Sprintf("%d/%d connections acquired (%.0f%%)", stat.AcquiredConns(), stat.MaxConns(), saturation*100)
+		// A saturated pool is reported but does not fail readiness: taking
+		// every busy replica out of rotation at once would only move the
+		// load onto fewer of them.
+		if stat.AcquiredConns() >= stat.MaxConns() {
+			return detail, warning{fmt.Errorf("pool saturated")}
+		}
+		return detail, nil
+	},
+}
+
+// @Summary	Liveness probe.
+// @Tags		Health
+// @Produce	application/json
+// @Success	200		{object}	HealthReport
+// @Router		/healthz [get]
+func healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, r, HealthReport{Status: "ok"})
+}
+
+// @Summary	Readiness probe checking the database, schema version and pool.
+// @Description	A saturated pool is reported as a warning but does not fail the probe.
+// @Tags		Health
+// @Produce	application/json
+// @Success	200		{object}	HealthReport
+// @Failure	503		{object}	HealthReport
+// @Router		/readyz [get]
+func readyz(w http.ResponseWriter, r *http.Request) {
+	report := HealthReport{Status: "ok", Checks: map[string]CheckResult{}}
+	if draining.Load() {
+		report.Status = "fail"
+		report.Checks["shutdown"] = CheckResult{Status: "fail", Error: "server is shutting down"}
+		writeHealth(w, r, report)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, c := range readinessChecks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			detail, err := c(ctx)
+			result := CheckResult{
+				Status:    "ok",
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+				Detail:    detail,
+			}
+			var warn warning
+			switch {
+			case errors.As(err, &warn):
+				result.Status = "warn"
+				result.Error = err.Error()
+			case err != nil:
+				result.Status = "fail"
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if result.Status == "fail" {
+				report.Status = "fail"
+			}
+		}()
+	}
+	wg.Wait()
+	writeHealth(w, r, report)
+}
+
+func writeHealth(w http.ResponseWriter, r *http.Request, report HealthReport) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != "ok" {
+		w.WriteHeader(503)
+		logging.FromContext(r.Context()).Warn("Readiness check failed", "checks", report.Checks)
+	}
+	err := json.NewEncoder(w).Encode(report)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error in writeHealth", "err", err)
+	}
+}
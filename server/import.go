@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	operations "go-test/database"
+	"go-test/importer"
+	"go-test/logging"
+	"go-test/middleware"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// maxImportBytes bounds the bodies of POST /import/{entity}.
+var maxImportBytes int64 = 64 << 20
+
+// uploadReadTimeout replaces the server's read timeout for upload bodies,
+// which take far longer to send than a JSON request.
+var uploadReadTimeout = 10 * time.Minute
+
+// extendReadDeadline gives the rest of the body uploadReadTimeout to
+// arrive.
+func extendReadDeadline(w http.ResponseWriter, r *http.Request) {
+	err := http.NewResponseController(w).SetReadDeadline(time.Now().Add(uploadReadTimeout))
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("Unable to extend the read deadline", "err", err)
+	}
+}
+
+// @Summary	Imports directors, actors, films or characters from CSV or JSON Lines.
+// @Description	CSV needs a header row. Columns and keys name the entity's fields,
+// @Description	unless renamed with map, e.g. map=Director ID=directedBy; map a
+// @Description	column to - to skip it. A column mapped to externalKey makes the
+// @Description	import upsert: rows replace the ones imported with the same key.
+// @Description	Every row is validated first and nothing is loaded unless all are
+// @Description	valid. With dry_run=true nothing is loaded at all.
+// @Tags		Import
+// @Accept		text/csv
+// @Accept		application/x-ndjson
+// @Produce	application/json
+// @Param		entity	path		string		true	"directors, actors, films or characters"
+// @Param		format	query		string		false	"csv or ndjson, by default taken from the Content-Type"
+// @Param		dry_run	query		bool		false	"Only validate the rows"
+// @Param		map		query		[]string	false	"Column mappings as <source>=<field>"	collectionFormat(multi)
+// @Success	200		{object}	importer.Report
+// @Failure	400		{object}	middleware.Problem
+// @Failure	409		{object}	middleware.Problem
+// @Failure	413		{object}	middleware.Problem
+// @Failure	415		{object}	middleware.Problem
+// @Failure	422		{object}	importer.Report
+// @Router		/import/{entity} [post]
+func postImport(w http.ResponseWriter, r *http.Request) {
+	extendReadDeadline(w, r)
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case "text/csv":
+			format = "csv"
+		case "application/x-ndjson", "application/jsonl":
+			format = "ndjson"
+		default:
+			w.Header().Set("Accept", "text/csv, application/x-ndjson")
+			middleware.WriteProblem(w, r, middleware.Problem{
+				Status: http.StatusUnsupportedMediaType,
+				Detail: "The request body must be sent as text/csv or application/x-ndjson, or the format given with ?format=.",
+			})
+			return
+		}
+	}
+	mapping, err := importer.ParseMapping(query["map"])
+	if err != nil {
+		middleware.WriteProblem(w, r, middleware.Problem{Status: http.StatusBadRequest, Detail: err.Error()})
+		return
+	}
+
+	report, err := importer.Run(r.Context(), http.MaxBytesReader(w, r.Body, maxImportBytes), importer.Options{
+		Entity:  r.PathValue("entity"),
+		Format:  format,
+		Mapping: mapping,
+		DryRun:  query.Get("dry_run") == "true",
+	})
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case err == nil:
+	case errors.Is(err, importer.ErrInvalidRows):
+		writeReport(w, r, http.StatusUnprocessableEntity, report)
+		return
+	case report == nil && errors.As(err, &maxBytesErr):
+		middleware.WriteProblem(w, r, middleware.Problem{
+			Status: http.StatusRequestEntityTooLarge,
+			Detail: fmt.Sprintf("The import exceeds %d bytes.", maxBytesErr.Limit),
+		})
+		return
+	case report == nil:
+		middleware.WriteProblem(w, r, middleware.Problem{Status: http.StatusBadRequest, Detail: err.Error()})
+		return
+	case operations.IsForeignKeyViolation(err):
+		middleware.WriteProblem(w, r, middleware.Problem{
+			Status: http.StatusConflict,
+			Detail: "A row refers to a record that does not exist, so nothing was imported.",
+		})
+		return
+	default:
+		w.WriteHeader(500)
+		w.Write([]byte("Error in ImportRows operation\n"))
+		logging.FromContext(r.Context()).Error("Error in ImportRows operation", "err", err)
+		return
+	}
+	writeReport(w, r, http.StatusOK, report)
+}
+
+func writeReport(w http.ResponseWriter, r *http.Request, status int, report *importer.Report) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(report)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error in postImport handler", "err", err)
+	}
+}
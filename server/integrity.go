@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"go-test/auth"
+	operations "go-test/database"
+	"go-test/logging"
+	"go-test/middleware"
+	"net/http"
+)
+
+// @Summary	Reports rows that break the data integrity rules.
+// @Description	Runs every rule, or those named with rule, over one consistent
+// @Description	snapshot. Rules that cannot run yet are reported as skipped.
+// @Tags		Admin
+// @Produce	application/json
+// @Produce	text/plain
+// @Param		rule	query		[]string	false	"Rules to run"	collectionFormat(multi)
+// @Param		format	query		string		false	"json (default) or table"
+// @Success	200		{object}	database.IntegrityReport
+// @Failure	400		{object}	middleware.Problem
+// @Failure	403		{object}	ResponseHTTP{}
+// @Router		/admin/check [get]
+func getIntegrity(w http.ResponseWriter, r *http.Request) {
+	checkIntegrity(w, r, false)
+}
+
+// @Summary	Repairs what the data integrity rules can fix safely, then reports.
+// @Description	Only rules marked fixable change data, such as trimming names. The
+// @Description	report lists the findings left afterwards. Fixes need a logged in
+// @Description	admin, even where the admin routes are open for development; use
+// @Description	the check -fix command there instead.
+// @Tags		Admin
+// @Produce	application/json
+// @Produce	text/plain
+// @Param		rule	query		[]string	false	"Rules to run"	collectionFormat(multi)
+// @Param		format	query		string		false	"json (default) or table"
+// @Success	200		{object}	database.IntegrityReport
+// @Failure	400		{object}	middleware.Problem
+// @Failure	401		{object}	ResponseHTTP{}
+// @Failure	403		{object}	middleware.Problem
+// @Router		/admin/check [post]
+func postIntegrity(w http.ResponseWriter, r *http.Request) {
+	if auth.UserFromContext(r.Context()) == nil {
+		middleware.WriteProblem(w, r, middleware.Problem{
+			Status: http.StatusForbidden,
+			Detail: "Integrity fixes are logged under the user who applied them; log in through /auth/login, or run the check -fix command instead.",
+		})
+		return
+	}
+	checkIntegrity(w, r, true)
+}
+
+func checkIntegrity(w http.ResponseWriter, r *http.Request, fix bool) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "table" {
+		middleware.WriteProblem(w, r, middleware.Problem{
+			Status: http.StatusBadRequest,
+			Detail: "Unknown format " + format + "; use json or table.",
+		})
+		return
+	}
+
+	report, err := operations.CheckIntegrity(r.Context(), r.URL.Query()["rule"], fix)
+	var ruleErr *operations.UnknownRuleError
+	if errors.As(err, &ruleErr) {
+		middleware.WriteProblem(w, r, middleware.Problem{
+			Status: http.StatusBadRequest,
+			Detail: ruleErr.Error(),
+			Field:  "rule",
+		})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("Error in CheckIntegrity operation\n"))
+		logging.FromContext(r.Context()).Error("Error in CheckIntegrity operation", "err", err)
+		return
+	}
+
+	if fix {
+		fixed := 0
+		for _, result := range report.Results {
+			fixed += result.Fixed
+		}
+		logging.FromContext(r.Context()).Info("Integrity fixes applied",
+			"rows", fixed, "by", auth.UserFromContext(r.Context()).Subject)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if format == "table" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		err = report.WriteTable(w)
+	} else {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		err = json.NewEncoder(w).Encode(report)
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error in checkIntegrity handler", "err", err)
+	}
+}
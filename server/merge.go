@@ -0,0 +1,216 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go-test/auth"
+	operations "go-test/database"
+	"go-test/logging"
+	"go-test/middleware"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	defaultDuplicateThreshold = 0.6
+	defaultDuplicateLimit     = 100
+	maxDuplicateLimit         = 1000
+)
+
+// MergeRequest names the records to merge into the one in the path.
+type MergeRequest struct {
+	Duplicates []int `json:"duplicates" validate:"required,min=1,dive,gt=0"`
+}
+
+// @Summary	Lists pairs of directors that may be the same person.
+// @Description	Names are compared without case, diacritics or middle names, and
+// @Description	transliterated to Latin letters, by trigram similarity.
+// @Tags		Directors
+// @Produce	application/json
+// @Param		threshold	query		number	false	"Minimum similarity from 0 to 1 (default 0.6)"
+// @Param		limit		query		int		false	"Maximum pairs returned (default 100, at most 1000)"
+// @Success	200		{array}		database.DuplicateCandidate
+// @Failure	400		{object}	middleware.Problem
+// @Failure	500		{object}	ResponseHTTP{}
+// @Router		/directors/duplicates [get]
+func getDirectorDuplicates(w http.ResponseWriter, r *http.Request) {
+	findDuplicates(w, r, "FindDuplicateDirectors", operations.FindDuplicateDirectors)
+}
+
+// @Summary	Lists pairs of actors that may be the same person.
+// @Description	Names are compared without case, diacritics or middle names, and
+// @Description	transliterated to Latin letters, by trigram similarity.
+// @Tags		Actors
+// @Produce	application/json
+// @Param		threshold	query		number	false	"Minimum similarity from 0 to 1 (default 0.6)"
+// @Param		limit		query		int		false	"Maximum pairs returned (default 100, at most 1000)"
+// @Success	200		{array}		database.DuplicateCandidate
+// @Failure	400		{object}	middleware.Problem
+// @Failure	500		{object}	ResponseHTTP{}
+// @Router		/actors/duplicates [get]
+func getActorDuplicates(w http.ResponseWriter, r *http.Request) {
+	findDuplicates(w, r, "FindDuplicateActors", operations.FindDuplicateActors)
+}
+
+// @Summary	Merges duplicate directors into this one.
+// @Description	The films of each duplicate are repointed to this director and the
+// @Description	duplicates deleted in one transaction, leaving an audit entry each.
+// @Description	Merges need a logged in user to audit them under.
+// @Tags		Directors
+// @Accept		application/json
+// @Produce	application/json
+// @Param		id		path		int						true	"Surviving director ID"
+// @Param		Merge	body		MergeRequest			true	"Directors to merge"
+// @Success	200		{object}	database.MergeResult
+// @Failure	401		{object}	ResponseHTTP{}
+// @Failure	403		{object}	middleware.Problem
+// @Failure	404		{object}	ResponseHTTP{}
+// @Failure	422		{object}	middleware.Problem
+// @Failure	500		{object}	ResponseHTTP{}
+// @Router		/directors/{id}/merge [post]
+func postDirectorMerge(w http.ResponseWriter, r *http.Request) {
+	merge(w, r, "Director", "MergeDirectors", operations.MergeDirectors)
+}
+
+// @Summary	Merges duplicate actors into this one.
+// @Description	The characters of each duplicate are repointed to this actor and the
+// @Description	duplicates deleted in one transaction, leaving an audit entry each.
+// @Description	Merges need a logged in user to audit them under.
+// @Tags		Actors
+// @Accept		application/json
+// @Produce	application/json
+// @Param		id		path		int						true	"Surviving actor ID"
+// @Param		Merge	body		MergeRequest			true	"Actors to merge"
+// @Success	200		{object}	database.MergeResult
+// @Failure	401		{object}	ResponseHTTP{}
+// @Failure	403		{object}	middleware.Problem
+// @Failure	404		{object}	ResponseHTTP{}
+// @Failure	422		{object}	middleware.Problem
+// @Failure	500		{object}	ResponseHTTP{}
+// @Router		/actors/{id}/merge [post]
+func postActorMerge(w http.ResponseWriter, r *http.Request) {
+	merge(w, r, "Actor", "MergeActors", operations.MergeActors)
+}
+
+func findDuplicates(w http.ResponseWriter, r *http.Request, operation string,
+	find func(ctx context.Context, threshold float64, limit int) ([]operations.DuplicateCandidate, error)) {
+	threshold, limit := defaultDuplicateThreshold, defaultDuplicateLimit
+	if v := r.URL.Query().Get("threshold"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 || f > 1 {
+			middleware.WriteProblem(w, r, middleware.Problem{
+				Status: http.StatusBadRequest,
+				Detail: "threshold must be a number above 0 and at most 1.",
+				Field:  "threshold",
+			})
+			return
+		}
+		threshold = f
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxDuplicateLimit {
+			middleware.WriteProblem(w, r, middleware.Problem{
+				Status: http.StatusBadRequest,
+				Detail: "limit must be a whole number from 1 to " + strconv.Itoa(maxDuplicateLimit) + ".",
+				Field:  "limit",
+			})
+			return
+		}
+		limit = n
+	}
+
+	candidates, err := find(r.Context(), threshold, limit)
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("Error in " + operation + " operation\n"))
+		logging.FromContext(r.Context()).Error("Error in "+operation+" operation", "err", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(candidates)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error in findDuplicates handler", "err", err)
+	}
+}
+
+func merge(w http.ResponseWriter, r *http.Request, entity, operation string,
+	fn func(ctx context.Context, survivor int, duplicates []int, by string) (*operations.MergeResult, error)) {
+	// Every merge is audited under the user who made it, so merges are
+	// refused without one, including while login is disabled.
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		middleware.WriteProblem(w, r, middleware.Problem{
+			Status: http.StatusForbidden,
+			Detail: "Merges are audited under the user who made them; log in through /auth/login first.",
+		})
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(404)
+		w.Write([]byte("Error: " + entity + " not found!\n"))
+		return
+	}
+
+	var req MergeRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	err = validate.Struct(req)
+	if err == nil && slices.Contains(req.Duplicates, id) {
+		err = errors.New("a record cannot be merged into itself")
+	}
+	if err == nil && len(slices.Compact(slices.Sorted(slices.Values(req.Duplicates)))) != len(req.Duplicates) {
+		err = errors.New("duplicates are listed more than once")
+	}
+	if err != nil {
+		middleware.WriteProblem(w, r, middleware.Problem{
+			Status: http.StatusUnprocessableEntity,
+			Detail: err.Error(),
+			Field:  "duplicates",
+		})
+		return
+	}
+
+	by := user.Subject
+	if user.Email != "" {
+		by = user.Email
+	}
+
+	result, err := fn(r.Context(), id, req.Duplicates, by)
+	var refErr *operations.ReferenceError
+	if errors.As(err, &refErr) {
+		middleware.WriteProblem(w, r, middleware.Problem{
+			Status: http.StatusUnprocessableEntity,
+			Detail: refErr.Error(),
+			Field:  "duplicates",
+		})
+		return
+	}
+	if err == pgx.ErrNoRows {
+		w.WriteHeader(404)
+		w.Write([]byte("Error: " + entity + " not found!\n"))
+		logging.FromContext(r.Context()).Info(entity + " not found")
+		return
+	}
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("Error in " + operation + " operation\n"))
+		logging.FromContext(r.Context()).Error("Error in "+operation+" operation", "err", err)
+		return
+	}
+	logging.FromContext(r.Context()).Info(entity+"s merged", "survivor", id, "merged", result.Merged, "by", by)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error in merge handler", "err", err)
+	}
+}
@@ -2,19 +2,76 @@ package server
 
 import (
 	"github.com/go-playground/validator/v10"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/swaggo/http-swagger"
 
+	"go-test/config"
 	_ "go-test/docs"
 	"go-test/middleware"
 
-	"log"
+	"context"
+	"errors"
+	"log/slog"
 	"net/http"
 	"time"
 )
 
 var validate *validator.Validate
 
-func Setup(host string) {
+// streamingRoutes write their response incrementally, so the timeout
+// middleware must not buffer them.
+var streamingRoutes = map[string]bool{
+	"GET /directors/":              true,
+	"GET /actors/":                 true,
+	"GET /films/":                  true,
+	"GET /characters/":             true,
+	"GET /filmCharacters/{filmId}": true,
+	"GET /export/{entity}":         true,
+	"GET /export":                  true,
+	"GET /admin/backup":            true,
+}
+
+// unboundedRoutes may run for as long as the transfer takes, unless a
+// route timeout is configured for them.
+var unboundedRoutes = map[string]bool{
+	"POST /import/{entity}": true,
+	"GET /export/{entity}":  true,
+	"GET /export":           true,
+	"GET /admin/backup":     true,
+	"POST /admin/restore":   true,
+}
+
+// uploadRoutes read bodies far larger than the idempotency middleware
+// fingerprints. They are safe to retry without a key: imports upsert by
+// external key and a restore replaces everything.
+var uploadRoutes = map[string]bool{
+	"POST /import/{entity}": true,
+	"POST /admin/restore":   true,
+}
+
+// unlimitedRoutes are polled by probes and scrapers, often all from one
+// address, so rate limiting them would fail health checks.
+var unlimitedRoutes = map[string]bool{
+	"GET /healthz": true,
+	"GET /readyz":  true,
+	"GET /metrics": true,
+	"GET /docs/":   true,
+}
+
+// Config is the HTTP configuration with the settings that config keeps as
+// text parsed by main.
+type Config struct {
+	HTTP              config.HTTP
+	RouteTimeouts     middleware.RouteTimeouts
+	RouteCacheControl middleware.RouteCacheControl
+	RateLimit         middleware.RateLimitConfig
+}
+
+// Setup serves the API until ctx is cancelled, then shuts down gracefully:
+// readiness starts failing, the listener closes after the drain delay,
+// in-flight requests get the shutdown timeout to finish and finally the
+// registered shutdown hooks run.
+func Setup(ctx context.Context, cfg Config) error {
 	router := http.NewServeMux()
 
 	router.HandleFunc("POST /directors/", postDirector)
@@ -22,12 +79,16 @@ func Setup(host string) {
 	router.HandleFunc("GET /directors/", getDirectors)
 	router.HandleFunc("PATCH /directors/", patchDirector)
 	router.HandleFunc("DELETE /directors/{id}", deleteDirector)
+	router.HandleFunc("GET /directors/duplicates", getDirectorDuplicates)
+	router.HandleFunc("POST /directors/{id}/merge", postDirectorMerge)
 
 	router.HandleFunc("POST /actors/", postActor)
 	router.HandleFunc("GET /actors/{id}", getActorById)
 	router.HandleFunc("GET /actors/", getActors)
 	router.HandleFunc("PATCH /actors/", patchActor)
 	router.HandleFunc("DELETE /actors/{id}", deleteActor)
+	router.HandleFunc("GET /actors/duplicates", getActorDuplicates)
+	router.HandleFunc("POST /actors/{id}/merge", postActorMerge)
 
 	router.HandleFunc("POST /films/", postFilm)
 	router.HandleFunc("GET /films/{id}", getFilmById)
@@ -42,6 +103,25 @@ func Setup(host string) {
 	router.HandleFunc("PATCH /characters/", patchCharacter)
 	router.HandleFunc("DELETE /characters/{id}", deleteCharacter)
 
+	router.HandleFunc("POST /batch", postBatch)
+	router.HandleFunc("POST /import/{entity}", postImport)
+	router.HandleFunc("GET /export/{entity}", getExport)
+	router.HandleFunc("GET /export", getExportSnapshot)
+
+	router.HandleFunc("GET /healthz", healthz)
+	router.HandleFunc("GET /readyz", readyz)
+
+	router.HandleFunc("GET /admin/pool", getPoolStats)
+	router.HandleFunc("GET /admin/backup", getBackup)
+	router.HandleFunc("POST /admin/restore", postRestore)
+	router.HandleFunc("GET /admin/check", getIntegrity)
+	router.HandleFunc("POST /admin/check", postIntegrity)
+
+	router.HandleFunc("GET /auth/login", login)
+	router.HandleFunc("GET /auth/callback", loginCallback)
+	router.HandleFunc("POST /auth/logout", logout)
+	router.HandleFunc("GET /auth/me", me)
+
 	router.HandleFunc("GET /docs/", httpSwagger.Handler(
 		httpSwagger.URL("/docs/doc.json"),
 		httpSwagger.UIConfig(map[string]string{
@@ -50,25 +130,95 @@ func Setup(host string) {
 		}),
 	))
 
+	// With a separate admin address /metrics is only served there, so it
+	// can be kept off the public listener.
+	var servers []*http.Server
+	if cfg.HTTP.MetricsAddr == "" {
+		router.Handle("GET /metrics", promhttp.Handler())
+	} else {
+		admin := http.NewServeMux()
+		admin.Handle("GET /metrics", promhttp.Handler())
+		servers = append(servers, &http.Server{
+			Addr:              cfg.HTTP.MetricsAddr,
+			ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+			Handler:           admin,
+		})
+	}
+
+	cfg.RateLimit.Exempt = unlimitedRoutes
 	validate = validator.New(validator.WithRequiredStructEnabled())
+	maxBodyBytes = int64(cfg.HTTP.MaxBodyBytes)
+	maxBatchOperations = cfg.HTTP.BatchMaxOperations
+	maxImportBytes = int64(cfg.HTTP.ImportMaxBytes)
+	uploadReadTimeout = cfg.HTTP.UploadReadTimeout
 
 	stack := middleware.CreateStack(
+		middleware.Metrics(router),
+		middleware.Tracing(router),
+		middleware.RequestID,
 		middleware.Logging,
+		middleware.Compress(middleware.CompressConfig{
+			MinSize: cfg.HTTP.CompressMinSize,
+			Brotli:  cfg.HTTP.CompressBrotli,
+		}),
+		middleware.Recover(cfg.HTTP.Dev),
 		// middleware.AllowCors,
-		// middleware.IsAuthed,
-		// middleware.CheckPermissions,
+		middleware.IsAuthed,
+		middleware.RateLimit(router, cfg.RateLimit),
+		middleware.CheckPermissions,
+		middleware.CacheControl(router, middleware.CacheControlConfig{
+			Default: cfg.HTTP.CacheControl,
+			Routes:  cfg.RouteCacheControl,
+		}),
+		middleware.Timeout(router, middleware.TimeoutConfig{
+			Default:   cfg.HTTP.HandlerTimeout,
+			Routes:    cfg.RouteTimeouts,
+			Streaming: streamingRoutes,
+			Unbounded: unboundedRoutes,
+		}),
+		middleware.Idempotency(router, middleware.IdempotencyConfig{
+			Window:  cfg.HTTP.IdempotencyWindow,
+			MaxBody: int64(cfg.HTTP.IdempotencyMaxBody),
+			Skip:    uploadRoutes,
+		}),
 	)
 
-	server := http.Server{
-		Addr:              host,
-		ReadHeaderTimeout: 5000 * time.Millisecond,
-		ReadTimeout:       5000 * time.Millisecond,
-		Handler:           http.TimeoutHandler(stack(router), 5*time.Second, ""),
+	servers = append([]*http.Server{{
+		Addr:              cfg.HTTP.Addr,
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+		ReadTimeout:       cfg.HTTP.ReadTimeout,
+		Handler:           stack(router),
+	}}, servers...)
+
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		go func() {
+			slog.Info("Starting server", "addr", server.Addr)
+			err := server.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				errs <- err
+			}
+		}()
+	}
+
+	var serveErr error
+	select {
+	case <-ctx.Done():
+		slog.Info("Shutting down", "drainDelay", cfg.HTTP.DrainDelay, "timeout", cfg.HTTP.ShutdownTimeout)
+		draining.Store(true)
+		time.Sleep(cfg.HTTP.DrainDelay)
+	case serveErr = <-errs:
+		slog.Error("Failed to start server", "err", serveErr)
 	}
 
-	log.Printf("Starting server on port %s\n", host)
-	err := server.ListenAndServe()
-	if err != nil {
-		log.Fatalf("Failed to start server: %s\n", err)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	defer cancel()
+	for _, server := range servers {
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			slog.Error("Server did not shut down cleanly", "addr", server.Addr, "err", err)
+			serveErr = errors.Join(serveErr, err)
+		}
 	}
+	return errors.Join(serveErr, runShutdownHooks(shutdownCtx))
 }
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+type shutdownHook struct {
+	name string
+	fn   func(context.Context) error
+}
+
+var (
+	hooksMu sync.Mutex
+	hooks   []shutdownHook
+)
+
+// OnShutdown registers fn to run once the servers have stopped. Hooks run in
+// reverse order of registration, like deferred calls.
+func OnShutdown(name string, fn func(context.Context) error) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	hooks = append(hooks, shutdownHook{name, fn})
+}
+
+func runShutdownHooks(ctx context.Context) error {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		err := hooks[i].fn(ctx)
+		if err != nil {
+			slog.Error("Shutdown hook failed", "hook", hooks[i].name, "err", err)
+			errs = append(errs, fmt.Errorf("%s: %w", hooks[i].name, err))
+		}
+	}
+	hooks = nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"go-test/logging"
+	"net/http"
+)
+
+const (
+	// listBufferSize is how much of a list is held back before the first
+	// write, so small lists still go out in one piece with a length.
+	listBufferSize = 32 << 10
+	// listFlushItems is how many list elements are written between flushes.
+	listFlushItems = 500
+)
+
+// streamWriter notes whether any of a streamed response went out yet.
+type streamWriter struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (w *streamWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *streamWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// writeList streams the elements each yields as a JSON array. The bytes are
+// exactly what json.NewEncoder(w).Encode writes for the collected slice,
+// including null when there are no elements and the trailing newline.
+// Errors before anything went out get a 500; after that, and when the
+// client goes away, the response is cut short.
+func writeList[T any](w http.ResponseWriter, r *http.Request, operation string, each func(fn func(*T) error) error) {
+	sw := &streamWriter{ResponseWriter: w}
+	rc := http.NewResponseController(w)
+	bw := bufio.NewWriterSize(sw, listBufferSize)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	n := 0
+	err := each(func(v *T) error {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			bw.WriteByte('[')
+		} else {
+			bw.WriteByte(',')
+		}
+		bw.Write(b)
+		n++
+		if n%listFlushItems != 0 {
+			return nil
+		}
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		rc.Flush()
+		return nil
+	})
+	if err == nil {
+		if n == 0 {
+			bw.WriteString("null\n")
+		} else {
+			bw.WriteString("]\n")
+		}
+		err = bw.Flush()
+	}
+	if err == nil {
+		return
+	}
+
+	if r.Context().Err() != nil {
+		logging.FromContext(r.Context()).Info("List cancelled", "operation", operation, "err", err)
+		panic(http.ErrAbortHandler)
+	}
+	logging.FromContext(r.Context()).Error("Error in "+operation+" operation", "err", err)
+	if sw.wrote {
+		panic(http.ErrAbortHandler)
+	}
+	w.Header().Del("Content-Type")
+	w.WriteHeader(500)
+	w.Write([]byte("Error in " + operation + " operation\n"))
+}